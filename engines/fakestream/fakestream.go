@@ -69,6 +69,11 @@ func (stream *FakeStream) Open(token string, token_secret string, oauth_token st
 	return nil
 }
 
+// VerifyCredentials accepts any credential.
+func VerifyCredentials(token string, token_secret string, oauth_token string, oauth_token_secret string) error {
+	return nil
+}
+
 func New() *FakeStream {
 	streamPtr := new(FakeStream)
 	return streamPtr
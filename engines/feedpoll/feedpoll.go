@@ -0,0 +1,258 @@
+package feedpoll
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxBodyBytes = 8 << 20
+	maxSeenItems = 1024
+)
+
+var ErrNotFeed = errors.New("feedpoll: document is neither RSS nor Atom")
+
+type HTTPStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (err HTTPStatusError) Error() string {
+	return "feedpoll: status=" + strconv.Itoa(err.StatusCode) + " " + err.Message
+}
+
+type Item struct {
+	Key       string
+	Guid      string
+	Link      string
+	Title     string
+	Rawsource []byte
+}
+
+// Feed holds the conditional GET validators and the keys of the items that
+// have already been seen for one feed url. A Feed must only be polled by one
+// goroutine at a time.
+type Feed struct {
+	Url           string
+	etag          string
+	last_modified string
+	seen          map[string]bool
+	seen_order    []string
+	primed        bool
+	rwlock        sync.RWMutex
+}
+
+type Result struct {
+	NotModified bool
+	NewItems    []Item
+}
+
+type Poller struct {
+	client    *http.Client
+	UserAgent string
+}
+
+func NewFeed(url string) *Feed {
+	feed := new(Feed)
+	feed.Url = url
+	feed.seen = make(map[string]bool)
+	return feed
+}
+
+func New(timeout time.Duration) *Poller {
+	poller := new(Poller)
+	poller.client = &http.Client{Timeout: timeout}
+	poller.UserAgent = "realtime-feedpoll"
+	return poller
+}
+
+func (feed *Feed) Host() string {
+	u, err := url.Parse(feed.Url)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// Primed is false until the first successful poll. Items present on the
+// first poll are recorded as seen but not reported as new.
+func (feed *Feed) Primed() bool {
+	feed.rwlock.RLock()
+	defer feed.rwlock.RUnlock()
+	return feed.primed
+}
+
+func (poller *Poller) Poll(feed *Feed) (*Result, error) {
+	req, err := http.NewRequest("GET", feed.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", poller.UserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+
+	feed.rwlock.RLock()
+	if feed.etag != "" {
+		req.Header.Set("If-None-Match", feed.etag)
+	}
+	if feed.last_modified != "" {
+		req.Header.Set("If-Modified-Since", feed.last_modified)
+	}
+	feed.rwlock.RUnlock()
+
+	resp, err := poller.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return &Result{NotModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		p, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, HTTPStatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Message:    string(p),
+		}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	items, err := ParseItems(body)
+	if err != nil {
+		return nil, err
+	}
+
+	feed.rwlock.Lock()
+	defer feed.rwlock.Unlock()
+
+	feed.etag = resp.Header.Get("ETag")
+	feed.last_modified = resp.Header.Get("Last-Modified")
+
+	result := new(Result)
+	for _, item := range items {
+		if feed.seen[item.Key] {
+			continue
+		}
+		feed.remember(item.Key)
+		if feed.primed {
+			result.NewItems = append(result.NewItems, item)
+		}
+	}
+	feed.primed = true
+	return result, nil
+}
+
+func (feed *Feed) remember(key string) {
+	feed.seen[key] = true
+	feed.seen_order = append(feed.seen_order, key)
+	if len(feed.seen_order) > maxSeenItems {
+		delete(feed.seen, feed.seen_order[0])
+		feed.seen_order = feed.seen_order[1:]
+	}
+}
+
+// ParseRetryAfter accepts both the delay-seconds and the HTTP-date forms of
+// the Retry-After header and returns zero when the header is absent or
+// unparsable.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+type rssDocument struct {
+	XMLName xml.Name `xml:"rss"`
+	Items   []struct {
+		Guid  string `xml:"guid"`
+		Link  string `xml:"link"`
+		Title string `xml:"title"`
+		Inner []byte `xml:",innerxml"`
+	} `xml:"channel>item"`
+}
+
+type atomDocument struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	Entries []struct {
+		Id    string `xml:"id"`
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Inner []byte `xml:",innerxml"`
+	} `xml:"entry"`
+}
+
+// ParseItems extracts the items of an RSS 2.0 or Atom document. Each item is
+// keyed by its guid/id, falling back to its link and finally to a hash of its
+// content.
+func ParseItems(body []byte) ([]Item, error) {
+	var items []Item
+
+	var rss rssDocument
+	if err := xml.Unmarshal(body, &rss); err == nil {
+		for _, i := range rss.Items {
+			item := Item{Guid: strings.TrimSpace(i.Guid), Link: strings.TrimSpace(i.Link), Title: i.Title, Rawsource: i.Inner}
+			item.Key = itemKey(item.Guid, item.Link, i.Inner)
+			items = append(items, item)
+		}
+		return items, nil
+	}
+
+	var atom atomDocument
+	if err := xml.Unmarshal(body, &atom); err == nil {
+		for _, e := range atom.Entries {
+			item := Item{Guid: strings.TrimSpace(e.Id), Title: e.Title, Rawsource: e.Inner}
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					item.Link = strings.TrimSpace(l.Href)
+					break
+				}
+			}
+			item.Key = itemKey(item.Guid, item.Link, e.Inner)
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, ErrNotFeed
+}
+
+func itemKey(guid string, link string, raw []byte) string {
+	if guid != "" {
+		return "guid:" + guid
+	}
+	if link != "" {
+		return "link:" + link
+	}
+	sum := sha1.Sum(bytes.TrimSpace(raw))
+	return "hash:" + hex.EncodeToString(sum[:])
+}
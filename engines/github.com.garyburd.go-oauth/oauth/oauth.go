@@ -62,13 +62,17 @@ package oauth
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/hmac"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -233,13 +237,14 @@ func nonce() string {
 }
 
 // oauthParams returns the OAuth request parameters for the given credentials,
-// method, URL and application params. See
+// method, URL and application params. A non-nil body is covered by an
+// oauth_body_hash parameter. See
 // http://tools.ietf.org/html/rfc5849#section-3.4 for more information about
 // signatures.
-func oauthParams(clientCredentials *Credentials, credentials *Credentials, method string, u *url.URL, form url.Values) map[string]string {
+func (c *Client) oauthParams(credentials *Credentials, method string, u *url.URL, form url.Values, body []byte) (map[string]string, error) {
 	oauthParams := map[string]string{
-		"oauth_consumer_key":     clientCredentials.Token,
-		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_consumer_key":     c.Credentials.Token,
+		"oauth_signature_method": c.SignatureMethod.String(),
 		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
 		"oauth_version":          "1.0",
 		"oauth_nonce":            nonce(),
@@ -247,6 +252,9 @@ func oauthParams(clientCredentials *Credentials, credentials *Credentials, metho
 	if credentials != nil {
 		oauthParams["oauth_token"] = credentials.Token
 	}
+	if body != nil {
+		oauthParams["oauth_body_hash"] = c.SignatureMethod.BodyHash(body)
+	}
 	if testingNonce != "" {
 		oauthParams["oauth_nonce"] = testingNonce
 	}
@@ -254,22 +262,111 @@ func oauthParams(clientCredentials *Credentials, credentials *Credentials, metho
 		oauthParams["oauth_timestamp"] = testingTimestamp
 	}
 
+	signature, err := c.sign(credentials, method, u, form, oauthParams)
+	if err != nil {
+		return nil, err
+	}
+	oauthParams["oauth_signature"] = signature
+	return oauthParams, nil
+}
+
+// signingKey returns the key of the HMAC and PLAINTEXT methods. See
+// http://tools.ietf.org/html/rfc5849#section-3.4.2.
+func signingKey(clientCredentials *Credentials, credentials *Credentials) []byte {
 	var key bytes.Buffer
 	key.Write(encode(clientCredentials.Secret, false))
 	key.WriteByte('&')
 	if credentials != nil {
 		key.Write(encode(credentials.Secret, false))
 	}
+	return key.Bytes()
+}
+
+// hmacSignature returns the base64 encoded HMAC signature of the signature
+// base string. See http://tools.ietf.org/html/rfc5849#section-3.4.2.
+func hmacSignature(h func() hash.Hash, clientCredentials *Credentials, credentials *Credentials, method string, u *url.URL, form url.Values, oauthParams map[string]string) string {
+	mac := hmac.New(h, signingKey(clientCredentials, credentials))
+	writeBaseString(mac, method, u, form, oauthParams)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
 
-	h := hmac.New(sha1.New, key.Bytes())
+// rsaDigest returns the SHA1 digest of the signature base string that
+// RSA-SHA1 signs. See http://tools.ietf.org/html/rfc5849#section-3.4.3.
+func rsaDigest(method string, u *url.URL, form url.Values, oauthParams map[string]string) []byte {
+	h := sha1.New()
 	writeBaseString(h, method, u, form, oauthParams)
-	sum := h.Sum(nil)
+	return h.Sum(nil)
+}
+
+// sign returns the signature of the request with the signature method of the
+// client.
+func (c *Client) sign(credentials *Credentials, method string, u *url.URL, form url.Values, oauthParams map[string]string) (string, error) {
+	switch c.SignatureMethod {
+	case HMACSHA1:
+		return hmacSignature(sha1.New, &c.Credentials, credentials, method, u, form, oauthParams), nil
+	case HMACSHA256:
+		return hmacSignature(sha256.New, &c.Credentials, credentials, method, u, form, oauthParams), nil
+	case PLAINTEXT:
+		return string(signingKey(&c.Credentials, credentials)), nil
+	case RSASHA1:
+		if c.PrivateKey == nil {
+			return "", errors.New("oauth: RSA-SHA1 signature method needs a private key")
+		}
+		sig, err := rsa.SignPKCS1v15(rand.Reader, c.PrivateKey, crypto.SHA1, rsaDigest(method, u, form, oauthParams))
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(sig), nil
+	}
+	return "", errors.New("oauth: unknown signature method")
+}
 
-	encodedSum := make([]byte, base64.StdEncoding.EncodedLen(len(sum)))
-	base64.StdEncoding.Encode(encodedSum, sum)
+// SignatureMethod is how requests are signed, see
+// http://tools.ietf.org/html/rfc5849#section-3.4.
+type SignatureMethod int
+
+const (
+	HMACSHA1 SignatureMethod = iota
+	RSASHA1
+	PLAINTEXT
+	HMACSHA256
+)
 
-	oauthParams["oauth_signature"] = string(encodedSum)
-	return oauthParams
+var signatureMethodNames = map[SignatureMethod]string{
+	HMACSHA1:   "HMAC-SHA1",
+	RSASHA1:    "RSA-SHA1",
+	PLAINTEXT:  "PLAINTEXT",
+	HMACSHA256: "HMAC-SHA256",
+}
+
+func (sm SignatureMethod) String() string {
+	if name, ok := signatureMethodNames[sm]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseSignatureMethod returns the method named by an oauth_signature_method
+// parameter.
+func ParseSignatureMethod(name string) (SignatureMethod, error) {
+	for sm, n := range signatureMethodNames {
+		if n == name {
+			return sm, nil
+		}
+	}
+	return 0, errors.New("oauth: unknown signature method " + name)
+}
+
+// BodyHash returns the oauth_body_hash of a request body, the base64 encoded
+// SHA256 digest for HMAC-SHA256 and the SHA1 digest otherwise. See
+// https://tools.ietf.org/id/draft-eaton-oauth-bodyhash-00.html.
+func (sm SignatureMethod) BodyHash(body []byte) string {
+	if sm == HMACSHA256 {
+		sum := sha256.Sum256(body)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	sum := sha1.Sum(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
 }
 
 // Client represents an OAuth client.
@@ -278,6 +375,11 @@ type Client struct {
 	TemporaryCredentialRequestURI string // Also known as request token URL.
 	ResourceOwnerAuthorizationURI string // Also known as authorization URL.
 	TokenRequestURI               string // Also known as access token URL.
+
+	// SignatureMethod defaults to HMAC-SHA1.
+	SignatureMethod SignatureMethod
+	// PrivateKey signs requests with the RSA-SHA1 signature method.
+	PrivateKey *rsa.PrivateKey
 }
 
 // Credentials represents client, temporary and token credentials.
@@ -306,7 +408,11 @@ func (c *Client) SignForm(credentials *Credentials, method, urlStr string, form
 	case u.RawQuery != "":
 		return errors.New("oauth: urlStr argument to SignForm must not include a query string")
 	}
-	for k, v := range oauthParams(&c.Credentials, credentials, method, u, form) {
+	p, err := c.oauthParams(credentials, method, u, form, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range p {
 		form.Set(k, v)
 	}
 	return nil
@@ -316,35 +422,126 @@ func (c *Client) SignForm(credentials *Credentials, method, urlStr string, form
 func (c *Client) SignParam(credentials *Credentials, method, urlStr string, params url.Values) {
 	u, _ := url.Parse(urlStr)
 	u.RawQuery = ""
-	for k, v := range oauthParams(&c.Credentials, credentials, method, u, params) {
+	p, _ := c.oauthParams(credentials, method, u, params, nil)
+	for k, v := range p {
 		params.Set(k, v)
 	}
 }
 
 // AuthorizationHeader returns the HTTP authorization header value for given
-// method, URL and parameters.
+// method, URL and parameters. Signing errors, which only the RSA-SHA1 method
+// has, are returned by SetAuthorizationHeader.
 //
 // See http://tools.ietf.org/html/rfc5849#section-3.5.1 for information about
 // transmitting OAuth parameters in an HTTP request header.
 func (c *Client) AuthorizationHeader(credentials *Credentials, method string, u *url.URL, params url.Values) string {
-	p := oauthParams(&c.Credentials, credentials, method, u, params)
+	p, _ := c.oauthParams(credentials, method, u, params, nil)
+	return authorizationHeader(p)
+}
+
+// SetAuthorizationHeader sets the Authorization header of a request with a
+// form, or no body, to the OAuth header for it.
+func (c *Client) SetAuthorizationHeader(header http.Header, credentials *Credentials, method string, u *url.URL, form url.Values) error {
+	p, err := c.oauthParams(credentials, method, u, form, nil)
+	if err != nil {
+		return err
+	}
+	header.Set("Authorization", authorizationHeader(p))
+	return nil
+}
+
+// SetBodyHashAuthorizationHeader sets the Authorization header of a request
+// whose body is not a form. The body is covered by the signature through the
+// oauth_body_hash parameter.
+func (c *Client) SetBodyHashAuthorizationHeader(header http.Header, credentials *Credentials, method string, u *url.URL, body []byte) error {
+	if body == nil {
+		body = []byte{}
+	}
+	p, err := c.oauthParams(credentials, method, u, nil, body)
+	if err != nil {
+		return err
+	}
+	header.Set("Authorization", authorizationHeader(p))
+	return nil
+}
+
+// authorizationHeader writes the OAuth parameters sorted by name.
+func authorizationHeader(p map[string]string) string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	var buf bytes.Buffer
-	buf.WriteString(`OAuth oauth_consumer_key="`)
-	buf.Write(encode(p["oauth_consumer_key"], false))
-	buf.WriteString(`", oauth_nonce="`)
-	buf.Write(encode(p["oauth_nonce"], false))
-	buf.WriteString(`", oauth_signature="`)
-	buf.Write(encode(p["oauth_signature"], false))
-	buf.WriteString(`", oauth_signature_method="HMAC-SHA1", oauth_timestamp="`)
-	buf.Write(encode(p["oauth_timestamp"], false))
-	if t, ok := p["oauth_token"]; ok {
-		buf.WriteString(`", oauth_token="`)
-		buf.Write(encode(t, false))
-	}
-	buf.WriteString(`", oauth_version="1.0"`)
+	buf.WriteString("OAuth ")
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(k)
+		buf.WriteString(`="`)
+		buf.Write(encode(p[k], false))
+		buf.WriteByte('"')
+	}
 	return buf.String()
 }
 
+// Signature returns the signature, with the signature method of the client,
+// for a request carrying the given OAuth protocol parameters. The
+// oauth_signature and realm parameters are excluded from the signature base
+// string. A server compares the result with the oauth_signature sent by the
+// client, or uses a Server which also verifies RSA-SHA1 signatures.
+func (c *Client) Signature(credentials *Credentials, method string, u *url.URL, form url.Values, oauthParams map[string]string) string {
+	sig, _ := c.sign(credentials, method, u, form, signedParams(oauthParams))
+	return sig
+}
+
+// signedParams returns the protocol parameters covered by the signature.
+func signedParams(oauthParams map[string]string) map[string]string {
+	p := make(map[string]string, len(oauthParams))
+	for k, v := range oauthParams {
+		if k == "oauth_signature" || k == "realm" {
+			continue
+		}
+		p[k] = v
+	}
+	return p
+}
+
+// ParseAuthorizationHeader returns the OAuth protocol parameters contained in
+// an Authorization header value of the form written by AuthorizationHeader.
+//
+// See http://tools.ietf.org/html/rfc5849#section-3.5.1.
+func ParseAuthorizationHeader(header string) (map[string]string, error) {
+	const prefix = "OAuth "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil, errors.New("oauth: authorization header is not an OAuth header")
+	}
+	params := make(map[string]string)
+	for _, part := range strings.Split(header[len(prefix):], ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.Index(part, "=")
+		if i < 0 {
+			return nil, errors.New("oauth: malformed authorization header parameter " + part)
+		}
+		key := part[:i]
+		value := part[i+1:]
+		if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+			return nil, errors.New("oauth: authorization header parameter " + key + " is not quoted")
+		}
+		value, err := url.PathUnescape(value[1 : len(value)-1])
+		if err != nil {
+			return nil, err
+		}
+		params[key] = value
+	}
+	return params, nil
+}
+
 // Get issues a GET to the specified URL with form added as a query string.
 func (c *Client) Get(client *http.Client, credentials *Credentials, urlStr string, form url.Values) (*http.Response, error) {
 	req, err := http.NewRequest("GET", urlStr, nil)
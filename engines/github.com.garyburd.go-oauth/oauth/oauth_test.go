@@ -16,8 +16,13 @@ package oauth
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func parseURL(urlStr string) *url.URL {
@@ -170,3 +175,244 @@ func TestAuthorizationHeader(t *testing.T) {
 		}
 	}
 }
+
+func TestParseAuthorizationHeader(t *testing.T) {
+	for _, ot := range oauthTests {
+		p, err := ParseAuthorizationHeader(ot.header)
+		if err != nil {
+			t.Errorf("ParseAuthorizationHeader(%q) returned error %v", ot.header, err)
+			continue
+		}
+		if p["oauth_consumer_key"] != ot.clientCredentials.Token || p["oauth_nonce"] != ot.nonce || p["oauth_timestamp"] != ot.timestamp {
+			t.Errorf("ParseAuthorizationHeader(%q) = %v", ot.header, p)
+		}
+	}
+	if _, err := ParseAuthorizationHeader(`Basic dXNlcjpwYXNz`); err == nil {
+		t.Errorf("ParseAuthorizationHeader accepted a Basic header")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	for _, ot := range oauthTests {
+		c := Client{Credentials: ot.clientCredentials}
+		p, err := ParseAuthorizationHeader(ot.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig := c.Signature(&ot.credentials, ot.method, ot.url, ot.appParams, p); sig != p["oauth_signature"] {
+			t.Errorf("signature for %s %s = %q, want %q", ot.method, ot.url, sig, p["oauth_signature"])
+		}
+	}
+}
+
+func TestPlaintextSignature(t *testing.T) {
+	c := Client{Credentials: Credentials{"dpf43f3p2l4k3l03", "kd94hf93k423kf44"}, SignatureMethod: PLAINTEXT}
+	header := c.AuthorizationHeader(&Credentials{"nnch734d00sl2jdk", "pfkkdhi9sl3r4s00"}, "GET", parseURL("http://photos.example.net/photos"), nil)
+	if !strings.Contains(header, `oauth_signature="kd94hf93k423kf44%26pfkkdhi9sl3r4s00"`) || !strings.Contains(header, `oauth_signature_method="PLAINTEXT"`) {
+		t.Errorf("PLAINTEXT header = %s", header)
+	}
+}
+
+func TestBodyHash(t *testing.T) {
+	// Example from the OAuth Request Body Hash draft
+	if got, want := HMACSHA1.BodyHash([]byte("Hello World!")), "Lve95gjOVATpfV8EL5X4nxwjKHE="; got != want {
+		t.Errorf("BodyHash() = %q, want %q", got, want)
+	}
+	if got, want := HMACSHA256.BodyHash(nil), "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="; got != want {
+		t.Errorf("BodyHash() = %q, want %q", got, want)
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		LookupConsumer: func(consumerKey string) (*Consumer, bool) {
+			switch consumerKey {
+			case "partner":
+				return &Consumer{Secret: "partner_secret"}, true
+			case "rsa_partner":
+				return &Consumer{PublicKey: &key.PublicKey}, true
+			}
+			return nil, false
+		},
+		LookupToken: func(consumerKey, token string) (string, bool) {
+			return "token_secret", consumerKey == "partner" && token == "token"
+		},
+		Methods: []SignatureMethod{HMACSHA1, HMACSHA256, RSASHA1, PLAINTEXT},
+	}
+	return s, key
+}
+
+func TestServerVerify(t *testing.T) {
+	s, key := newTestServer(t)
+	u := parseURL("https://example.com/hook?x=1")
+	form := url.Values{"track": {"a b"}}
+	formHeader := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+
+	for _, sm := range []SignatureMethod{HMACSHA1, HMACSHA256, RSASHA1, PLAINTEXT} {
+		c := Client{Credentials: Credentials{"partner", "partner_secret"}, SignatureMethod: sm}
+		var credentials *Credentials
+		if sm == RSASHA1 {
+			c.Credentials = Credentials{Token: "rsa_partner"}
+			c.PrivateKey = key
+		} else {
+			credentials = &Credentials{"token", "token_secret"}
+		}
+
+		header := http.Header{"Content-Type": formHeader["Content-Type"]}
+		if err := c.SetAuthorizationHeader(header, credentials, "POST", u, form); err != nil {
+			t.Fatalf("%v: SetAuthorizationHeader() returned %v", sm, err)
+		}
+		if _, err := s.Verify("POST", u, header, []byte(form.Encode())); err != nil {
+			t.Errorf("%v: Verify() = %v", sm, err)
+		}
+
+		// PLAINTEXT does not sign the request
+		if sm == PLAINTEXT {
+			continue
+		}
+		header = http.Header{"Content-Type": formHeader["Content-Type"]}
+		c.SetAuthorizationHeader(header, credentials, "POST", u, form)
+		if _, err := s.Verify("POST", u, header, []byte("track=other")); err != ErrSignature {
+			t.Errorf("%v: Verify() of a changed form = %v, want ErrSignature", sm, err)
+		}
+	}
+
+	c := Client{Credentials: Credentials{"partner", "wrong"}}
+	header := http.Header{}
+	c.SetAuthorizationHeader(header, nil, "GET", u, nil)
+	if _, err := s.Verify("GET", u, header, nil); err != ErrSignature {
+		t.Errorf("Verify() with a wrong secret = %v, want ErrSignature", err)
+	}
+	c = Client{Credentials: Credentials{"stranger", "partner_secret"}}
+	c.SetAuthorizationHeader(header, nil, "GET", u, nil)
+	if _, err := s.Verify("GET", u, header, nil); err != ErrUnknownConsumer {
+		t.Errorf("Verify() of an unknown consumer = %v, want ErrUnknownConsumer", err)
+	}
+	if _, err := s.Verify("GET", u, http.Header{}, nil); err != ErrNoAuthorization {
+		t.Errorf("Verify() without authorization = %v, want ErrNoAuthorization", err)
+	}
+}
+
+func TestServerConsumerMethods(t *testing.T) {
+	s, _ := newTestServer(t)
+	u := parseURL("https://example.com/hook")
+
+	// an RSA consumer has no secret, signing with an empty one must not pass
+	for _, sm := range []SignatureMethod{HMACSHA1, HMACSHA256, PLAINTEXT} {
+		c := Client{Credentials: Credentials{Token: "rsa_partner"}, SignatureMethod: sm}
+		header := http.Header{}
+		if err := c.SetAuthorizationHeader(header, nil, "GET", u, nil); err != nil {
+			t.Fatalf("%v: SetAuthorizationHeader() returned %v", sm, err)
+		}
+		if _, err := s.Verify("GET", u, header, nil); err != ErrSignatureMethod {
+			t.Errorf("%v: Verify() of an RSA consumer = %v, want ErrSignatureMethod", sm, err)
+		}
+	}
+
+	s.LookupConsumer = func(consumerKey string) (*Consumer, bool) {
+		return &Consumer{Secret: "partner_secret", Methods: []SignatureMethod{HMACSHA256}}, true
+	}
+	c := Client{Credentials: Credentials{"partner", "partner_secret"}, SignatureMethod: HMACSHA1}
+	header := http.Header{}
+	c.SetAuthorizationHeader(header, nil, "GET", u, nil)
+	if _, err := s.Verify("GET", u, header, nil); err != ErrSignatureMethod {
+		t.Errorf("Verify() with a method the consumer does not declare = %v, want ErrSignatureMethod", err)
+	}
+	c.SignatureMethod = HMACSHA256
+	header = http.Header{}
+	c.SetAuthorizationHeader(header, nil, "GET", u, nil)
+	if _, err := s.Verify("GET", u, header, nil); err != nil {
+		t.Errorf("Verify() with a declared method = %v", err)
+	}
+}
+
+func TestServerBodyHash(t *testing.T) {
+	s, _ := newTestServer(t)
+	u := parseURL("https://example.com/hook")
+	body := []byte(`{"account_ids":["1"]}`)
+	c := Client{Credentials: Credentials{"partner", "partner_secret"}, SignatureMethod: HMACSHA256}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	if err := c.SetBodyHashAuthorizationHeader(header, nil, "POST", u, body); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify("POST", u, header, []byte(`{"account_ids":["2"]}`)); err != ErrBodyHash {
+		t.Errorf("Verify() of a changed body = %v, want ErrBodyHash", err)
+	}
+	if _, err := s.Verify("POST", u, header, body); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+
+	// a body the signature does not cover is refused
+	c.SetAuthorizationHeader(header, nil, "POST", u, nil)
+	if _, err := s.Verify("POST", u, header, body); err != ErrBodyHash {
+		t.Errorf("Verify() without body hash = %v, want ErrBodyHash", err)
+	}
+}
+
+func TestServerReplay(t *testing.T) {
+	defer func() {
+		testingTimestamp = ""
+		timeNow = time.Now
+	}()
+	s, _ := newTestServer(t)
+	s.Methods = nil
+	u := parseURL("https://example.com/hook")
+	c := Client{Credentials: Credentials{"partner", "partner_secret"}}
+
+	header := http.Header{}
+	c.SetAuthorizationHeader(header, nil, "GET", u, nil)
+	if _, err := s.Verify("GET", u, header, nil); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if _, err := s.Verify("GET", u, header, nil); err != ErrNonce {
+		t.Errorf("Verify() of a replay = %v, want ErrNonce", err)
+	}
+
+	testingTimestamp = "1355795903"
+	c.SetAuthorizationHeader(header, nil, "GET", u, nil)
+	if _, err := s.Verify("GET", u, header, nil); err != ErrTimestamp {
+		t.Errorf("Verify() of an old request = %v, want ErrTimestamp", err)
+	}
+	timeNow = func() time.Time { return time.Unix(1355795903, 0).Add(DefaultMaxSkew - time.Second) }
+	if _, err := s.Verify("GET", u, header, nil); err != nil {
+		t.Errorf("Verify() within the skew = %v", err)
+	}
+
+	c.SignatureMethod = PLAINTEXT
+	c.SetAuthorizationHeader(header, nil, "GET", u, nil)
+	if _, err := s.Verify("GET", u, header, nil); err != ErrSignatureMethod {
+		t.Errorf("Verify() of PLAINTEXT by default = %v, want ErrSignatureMethod", err)
+	}
+}
+
+func TestServerNonceWindow(t *testing.T) {
+	s := &Server{}
+	window := 2 * DefaultMaxSkew
+	start := time.Unix(1355795903, 0)
+	steps := []struct {
+		after time.Duration
+		nonce string
+		want  bool
+	}{
+		{0, "a", true},
+		{time.Second, "a", false},
+		{window - time.Second, "b", true},
+		{window, "a", false},
+		{window + time.Second, "b", false},
+		{2*window - time.Second, "a", false},
+		{2 * window, "a", true},
+		{2 * window, "b", true},
+		{2*window + time.Second, "b", false},
+		{5 * window, "b", true},
+	}
+	for _, step := range steps {
+		if got := s.UseNonce(step.nonce, start.Add(step.after)); got != step.want {
+			t.Errorf("UseNonce(%q) after %v = %v, want %v", step.nonce, step.after, got, step.want)
+		}
+	}
+}
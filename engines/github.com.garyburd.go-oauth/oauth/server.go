@@ -0,0 +1,235 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package oauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSkew is how far the timestamp of a request may be from the clock
+// of a Server that does not set MaxSkew.
+const DefaultMaxSkew = 5 * time.Minute
+
+// timeNow is replaced by tests.
+var timeNow = time.Now
+
+var (
+	ErrNoAuthorization = errors.New("oauth: request has no OAuth authorization header")
+	ErrUnknownConsumer = errors.New("oauth: unknown consumer key or token")
+	ErrSignatureMethod = errors.New("oauth: signature method not accepted")
+	ErrBodyHash        = errors.New("oauth: body hash does not match the body")
+	ErrSignature       = errors.New("oauth: signature does not match")
+	ErrTimestamp       = errors.New("oauth: timestamp outside of the accepted window")
+	ErrNonce           = errors.New("oauth: nonce was used before")
+)
+
+// Consumer is what a Server knows of a client. The HMAC and PLAINTEXT
+// methods use the secret and are refused without one, RSA-SHA1 the public
+// key.
+type Consumer struct {
+	Secret    string
+	PublicKey *rsa.PublicKey
+	// Methods are the signature methods the consumer may sign with, any the
+	// server accepts and the consumer has a key for when empty.
+	Methods []SignatureMethod
+}
+
+func (c *Consumer) accepts(sm SignatureMethod) bool {
+	if sm == RSASHA1 && c.PublicKey == nil || sm != RSASHA1 && c.Secret == "" {
+		return false
+	}
+	if len(c.Methods) == 0 {
+		return true
+	}
+	for _, m := range c.Methods {
+		if m == sm {
+			return true
+		}
+	}
+	return false
+}
+
+// Server verifies the signature of requests made to it, see
+// http://tools.ietf.org/html/rfc5849#section-3.2.
+type Server struct {
+	// LookupConsumer returns the consumer of a consumer key.
+	LookupConsumer func(consumerKey string) (*Consumer, bool)
+	// LookupToken returns the secret of a token issued to a consumer. Without
+	// it only requests that carry no token, two-legged requests, verify.
+	LookupToken func(consumerKey, token string) (string, bool)
+
+	// Methods are the accepted signature methods, HMAC-SHA1, HMAC-SHA256 and
+	// RSA-SHA1 when empty. PLAINTEXT sends the secrets themselves and should
+	// only be accepted over TLS.
+	Methods []SignatureMethod
+	// MaxSkew defaults to DefaultMaxSkew, nonces are remembered for twice
+	// this window.
+	MaxSkew time.Duration
+
+	mu sync.Mutex
+	// nonces are kept in two generations, the older one is dropped whole
+	// when a window has passed since the newer one started.
+	nonces      map[string]bool
+	oldNonces   map[string]bool
+	noncesSince time.Time
+}
+
+// Verify checks the OAuth authorization header of a request against the
+// request method, the absolute URL the client signed and the body. A form
+// body is covered through its parameters, any other body through the
+// oauth_body_hash parameter, which a request with such a body must carry. It
+// returns the OAuth protocol parameters of a verified request.
+func (s *Server) Verify(method string, u *url.URL, header http.Header, body []byte) (map[string]string, error) {
+	params, err := ParseAuthorizationHeader(header.Get("Authorization"))
+	if err != nil {
+		return nil, ErrNoAuthorization
+	}
+	if v, ok := params["oauth_version"]; ok && v != "1.0" {
+		return nil, errors.New("oauth: unsupported version " + v)
+	}
+
+	sm, err := ParseSignatureMethod(params["oauth_signature_method"])
+	if err != nil || !s.accepts(sm) {
+		return nil, ErrSignatureMethod
+	}
+
+	consumerKey := params["oauth_consumer_key"]
+	consumer, ok := s.LookupConsumer(consumerKey)
+	if !ok || consumer == nil {
+		return nil, ErrUnknownConsumer
+	}
+	if !consumer.accepts(sm) {
+		return nil, ErrSignatureMethod
+	}
+	var credentials *Credentials
+	if token, ok := params["oauth_token"]; ok {
+		if s.LookupToken == nil {
+			return nil, ErrUnknownConsumer
+		}
+		secret, ok := s.LookupToken(consumerKey, token)
+		if !ok {
+			return nil, ErrUnknownConsumer
+		}
+		credentials = &Credentials{Token: token, Secret: secret}
+	}
+
+	var form url.Values
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		if _, ok := params["oauth_body_hash"]; ok {
+			return nil, ErrBodyHash
+		}
+		if form, err = url.ParseQuery(string(body)); err != nil {
+			return nil, err
+		}
+	} else if bodyHash, ok := params["oauth_body_hash"]; ok || len(body) > 0 {
+		if !hmac.Equal([]byte(bodyHash), []byte(sm.BodyHash(body))) {
+			return nil, ErrBodyHash
+		}
+	}
+
+	if !verifySignature(sm, consumer, credentials, method, u, form, params) {
+		return nil, ErrSignature
+	}
+
+	now := timeNow()
+	secs, err := strconv.ParseInt(params["oauth_timestamp"], 10, 64)
+	if err != nil {
+		return nil, ErrTimestamp
+	}
+	maxSkew := s.maxSkew()
+	if skew := now.Sub(time.Unix(secs, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, ErrTimestamp
+	}
+	// nonces are unique per consumer, token and timestamp, section 3.3
+	nonce := params["oauth_nonce"]
+	if nonce == "" || !s.UseNonce(consumerKey+"&"+params["oauth_token"]+"&"+params["oauth_timestamp"]+"&"+nonce, now) {
+		return nil, ErrNonce
+	}
+	return params, nil
+}
+
+func verifySignature(sm SignatureMethod, consumer *Consumer, credentials *Credentials, method string, u *url.URL, form url.Values, params map[string]string) bool {
+	sent := params["oauth_signature"]
+	if !consumer.accepts(sm) {
+		return false
+	}
+	if sm == RSASHA1 {
+		sig, err := base64.StdEncoding.DecodeString(sent)
+		if err != nil {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(consumer.PublicKey, crypto.SHA1, rsaDigest(method, u, form, signedParams(params)), sig) == nil
+	}
+	c := Client{Credentials: Credentials{Token: params["oauth_consumer_key"], Secret: consumer.Secret}, SignatureMethod: sm}
+	expected, err := c.sign(credentials, method, u, form, signedParams(params))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(expected), []byte(sent))
+}
+
+func (s *Server) accepts(sm SignatureMethod) bool {
+	if len(s.Methods) == 0 {
+		return sm == HMACSHA1 || sm == HMACSHA256 || sm == RSASHA1
+	}
+	for _, m := range s.Methods {
+		if m == sm {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) maxSkew() time.Duration {
+	if s.MaxSkew > 0 {
+		return s.MaxSkew
+	}
+	return DefaultMaxSkew
+}
+
+// UseNonce records the nonce and reports whether it was unseen within the
+// window. A nonce is remembered for at least the window and at most twice
+// it. Servers verifying other signatures too may keep their nonces here,
+// prefixed so they cannot meet those of OAuth requests.
+func (s *Server) UseNonce(nonce string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window := 2 * s.maxSkew()
+	if elapsed := now.Sub(s.noncesSince); s.nonces == nil || elapsed >= window {
+		s.oldNonces = s.nonces
+		if elapsed >= 2*window {
+			s.oldNonces = nil
+		}
+		s.nonces = make(map[string]bool)
+		s.noncesSince = now
+	}
+	if s.nonces[nonce] || s.oldNonces[nonce] {
+		return false
+	}
+	s.nonces[nonce] = true
+	return true
+}
@@ -0,0 +1,386 @@
+// Package oauth2 is a client for OAuth 2.0 (RFC 6749).
+//
+// # Grants
+//
+// A Config describes the client and the token endpoint of the server. The
+// ClientCredentials method requests a token for the client itself. The
+// authorization code grant starts with AuthCodeURL, which the user is
+// redirected to, and ends with Exchange once the server redirected back with a
+// code. Both take a PKCE (RFC 7636) verifier created with NewVerifier. The
+// Refresh method trades a refresh token for a new token.
+//
+// # Making Requests
+//
+// A CachingTokenSource hands out a token until shortly before it expires and
+// gets a new one ahead of time, by refresh token when it has one. The
+// Transport adds the token of a TokenSource to every request as a bearer
+// token.
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRefreshBefore is how long before expiry a CachingTokenSource gets a
+// new token.
+const DefaultRefreshBefore = 1 * time.Minute
+
+const maxTokenResponseBytes = 1 << 20
+
+// timeNow is replaced by tests.
+var timeNow = time.Now
+
+// Config is an OAuth 2.0 client and the endpoints of the server it uses.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+
+	// AuthURL is the authorization endpoint, needed for the authorization code
+	// grant only.
+	AuthURL  string
+	TokenURL string
+
+	RedirectURL string
+	Scopes      []string
+
+	// AuthInParams sends the client id and secret in the form of token
+	// requests instead of with HTTP Basic authentication.
+	AuthInParams bool
+}
+
+// Token is what the token endpoint grants.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid returns true when the token has an access token that has not
+// expired, a zero Expiry never expires.
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && !t.expiresWithin(0)
+}
+
+func (t *Token) expiresWithin(d time.Duration) bool {
+	if t.Expiry.IsZero() {
+		return false
+	}
+	return !timeNow().Add(d).Before(t.Expiry)
+}
+
+// Error is the error response of a token endpoint, see section 5.2 of the
+// RFC.
+type Error struct {
+	StatusCode  int
+	Code        string
+	Description string
+	URI         string
+}
+
+func (err *Error) Error() string {
+	s := "oauth2: status=" + strconv.Itoa(err.StatusCode)
+	if err.Code != "" {
+		s += " error=" + err.Code
+	}
+	if err.Description != "" {
+		s += " " + err.Description
+	}
+	return s
+}
+
+// NewVerifier returns a PKCE code verifier, 43 characters of URL safe base64
+// over 32 random bytes.
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 code challenge of a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint to redirect the
+// user to. State is returned by the server with the code, verifier adds a
+// PKCE challenge when not empty.
+func (c *Config) AuthCodeURL(state string, verifier string) string {
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {c.ClientID},
+	}
+	if c.RedirectURL != "" {
+		params.Set("redirect_uri", c.RedirectURL)
+	}
+	if len(c.Scopes) > 0 {
+		params.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	if verifier != "" {
+		params.Set("code_challenge", Challenge(verifier))
+		params.Set("code_challenge_method", "S256")
+	}
+	if strings.Contains(c.AuthURL, "?") {
+		return c.AuthURL + "&" + params.Encode()
+	}
+	return c.AuthURL + "?" + params.Encode()
+}
+
+// ClientCredentials requests a token for the client itself.
+func (c *Config) ClientCredentials(client *http.Client) (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	return c.requestToken(client, form)
+}
+
+// Exchange trades the code the server redirected back with for a token,
+// verifier is the one given to AuthCodeURL.
+func (c *Config) Exchange(client *http.Client, code string, verifier string) (*Token, error) {
+	form := url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {code},
+	}
+	if c.RedirectURL != "" {
+		form.Set("redirect_uri", c.RedirectURL)
+	}
+	if verifier != "" {
+		form.Set("code_verifier", verifier)
+	}
+	return c.requestToken(client, form)
+}
+
+// Refresh trades a refresh token for a new token. The new token keeps the
+// refresh token when the server does not issue another one.
+func (c *Config) Refresh(client *http.Client, refreshToken string) (*Token, error) {
+	if refreshToken == "" {
+		return nil, errors.New("oauth2: no refresh token")
+	}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	t, err := c.requestToken(client, form)
+	if err != nil {
+		return nil, err
+	}
+	if t.RefreshToken == "" {
+		t.RefreshToken = refreshToken
+	}
+	return t, nil
+}
+
+func (c *Config) requestToken(client *http.Client, form url.Values) (*Token, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if c.AuthInParams {
+		form.Set("client_id", c.ClientID)
+		if c.ClientSecret != "" {
+			form.Set("client_secret", c.ClientSecret)
+		}
+	}
+	req, err := http.NewRequest("POST", c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !c.AuthInParams {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	p, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTokenResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	return parseTokenResponse(resp.StatusCode, resp.Header.Get("Content-Type"), p)
+}
+
+// tokenResponse is section 5.1 of the RFC, some servers send expires_in as
+// a string.
+type tokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	TokenType        string      `json:"token_type"`
+	RefreshToken     string      `json:"refresh_token"`
+	Scope            string      `json:"scope"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+	ErrorURI         string      `json:"error_uri"`
+}
+
+func parseTokenResponse(statusCode int, contentType string, p []byte) (*Token, error) {
+	var tr tokenResponse
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" || mediaType == "text/plain" {
+		values, err := url.ParseQuery(string(p))
+		if err != nil {
+			return nil, err
+		}
+		tr = tokenResponse{
+			AccessToken:      values.Get("access_token"),
+			TokenType:        values.Get("token_type"),
+			RefreshToken:     values.Get("refresh_token"),
+			Scope:            values.Get("scope"),
+			ExpiresIn:        json.Number(values.Get("expires_in")),
+			Error:            values.Get("error"),
+			ErrorDescription: values.Get("error_description"),
+			ErrorURI:         values.Get("error_uri"),
+		}
+	} else if err := json.Unmarshal(p, &tr); err != nil && statusCode == http.StatusOK {
+		return nil, fmt.Errorf("oauth2: cannot parse token response: %v", err)
+	}
+
+	if statusCode != http.StatusOK || tr.Error != "" {
+		return nil, &Error{StatusCode: statusCode, Code: tr.Error, Description: tr.ErrorDescription, URI: tr.ErrorURI}
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("oauth2: token response has no access_token")
+	}
+	t := &Token{
+		AccessToken:  tr.AccessToken,
+		TokenType:    tr.TokenType,
+		RefreshToken: tr.RefreshToken,
+		Scope:        tr.Scope,
+	}
+	if tr.ExpiresIn != "" {
+		seconds, err := tr.ExpiresIn.Int64()
+		if err != nil {
+			return nil, errors.New("oauth2: expires_in is not a number")
+		}
+		if seconds > 0 {
+			t.Expiry = timeNow().Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	return t, nil
+}
+
+// TokenSource hands out tokens for requests.
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+// CachingTokenSource keeps a token until RefreshBefore its expiry. It then
+// gets a new one by refresh token when it has one and by fetch otherwise,
+// while the current token stays in use should that fail before it expires.
+type CachingTokenSource struct {
+	config *Config
+	client *http.Client
+	fetch  func() (*Token, error)
+
+	// RefreshBefore defaults to DefaultRefreshBefore.
+	RefreshBefore time.Duration
+	// OnToken, when set, is called with every new token, e.g. to keep a
+	// refresh token the server rotated.
+	OnToken func(*Token)
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// TokenSource returns a source starting with token t, which may be nil, that
+// refreshes it with its refresh token.
+func (c *Config) TokenSource(client *http.Client, t *Token) *CachingTokenSource {
+	return &CachingTokenSource{config: c, client: client, token: t}
+}
+
+// ClientCredentialsSource returns a source of client credentials tokens.
+func (c *Config) ClientCredentialsSource(client *http.Client) *CachingTokenSource {
+	s := &CachingTokenSource{config: c, client: client}
+	s.fetch = func() (*Token, error) { return c.ClientCredentials(client) }
+	return s
+}
+
+// Token returns the cached token, or a new one when the cached one is due.
+func (s *CachingTokenSource) Token() (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.RefreshBefore
+	if before == 0 {
+		before = DefaultRefreshBefore
+	}
+	if s.token.Valid() && !s.token.expiresWithin(before) {
+		return s.token, nil
+	}
+
+	var t *Token
+	var err error
+	switch {
+	case s.token != nil && s.token.RefreshToken != "":
+		t, err = s.config.Refresh(s.client, s.token.RefreshToken)
+	case s.fetch != nil:
+		t, err = s.fetch()
+	default:
+		err = errors.New("oauth2: token expired and cannot be refreshed")
+	}
+	if err != nil {
+		if s.token.Valid() {
+			return s.token, nil
+		}
+		return nil, err
+	}
+	s.token = t
+	if s.OnToken != nil {
+		s.OnToken(t)
+	}
+	return t, nil
+}
+
+// Transport is an http.RoundTripper that adds the token of Source to every
+// request as a bearer token.
+type Transport struct {
+	Source TokenSource
+	// Base defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	// a RoundTripper must not modify the request it was given
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// NewClient returns an http.Client whose requests carry the tokens of source.
+func NewClient(source TokenSource) *http.Client {
+	return &http.Client{Transport: &Transport{Source: source}}
+}
@@ -0,0 +1,254 @@
+package oauth2
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokenServer answers token requests with the responses in order and keeps
+// the requests it saw.
+type tokenServer struct {
+	*httptest.Server
+	responses []string
+	forms     []url.Values
+	auths     []string
+}
+
+func newTokenServer(responses ...string) *tokenServer {
+	ts := &tokenServer{responses: responses}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ts.forms = append(ts.forms, r.PostForm)
+		ts.auths = append(ts.auths, r.Header.Get("Authorization"))
+		response := ts.responses[0]
+		ts.responses = ts.responses[1:]
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(response, `"error"`) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(response))
+	}))
+	return ts
+}
+
+func setTime(t time.Time) func() {
+	timeNow = func() time.Time { return t }
+	return func() { timeNow = time.Now }
+}
+
+func TestChallenge(t *testing.T) {
+	// Appendix B of RFC 7636
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	if got, want := Challenge(verifier), "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("Challenge() = %q, want %q", got, want)
+	}
+	v, err := NewVerifier()
+	if err != nil || len(v) != 43 {
+		t.Errorf("NewVerifier() = %q, %v, want 43 characters", v, err)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	c := &Config{ClientID: "client", AuthURL: "https://example.com/authorize", RedirectURL: "https://app/cb", Scopes: []string{"read", "write"}}
+	u, err := url.Parse(c.AuthCodeURL("xyz", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"client"},
+		"redirect_uri":          {"https://app/cb"},
+		"scope":                 {"read write"},
+		"state":                 {"xyz"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}
+	if got := u.Query(); got.Encode() != want.Encode() {
+		t.Errorf("AuthCodeURL() query = %v, want %v", got, want)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	defer setTime(time.Unix(1000, 0))()
+	ts := newTokenServer(`{"access_token":"at","token_type":"bearer","expires_in":3600}`)
+	defer ts.Close()
+
+	c := &Config{ClientID: "id", ClientSecret: "secret", TokenURL: ts.URL, Scopes: []string{"a"}}
+	token, err := c.ClientCredentials(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "at" || !token.Expiry.Equal(time.Unix(4600, 0)) {
+		t.Errorf("token = %+v", token)
+	}
+	if got := ts.forms[0].Get("grant_type"); got != "client_credentials" {
+		t.Errorf("grant_type = %q", got)
+	}
+	if got := ts.forms[0].Get("scope"); got != "a" {
+		t.Errorf("scope = %q", got)
+	}
+	if got, want := ts.auths[0], "Basic aWQ6c2VjcmV0"; got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestExchangeInParams(t *testing.T) {
+	ts := newTokenServer(`{"access_token":"at","refresh_token":"rt","expires_in":"60"}`)
+	defer ts.Close()
+
+	c := &Config{ClientID: "id", TokenURL: ts.URL, RedirectURL: "https://app/cb", AuthInParams: true}
+	token, err := c.Exchange(nil, "code", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.RefreshToken != "rt" {
+		t.Errorf("RefreshToken = %q", token.RefreshToken)
+	}
+	form := ts.forms[0]
+	for key, want := range map[string]string{"grant_type": "authorization_code", "code": "code", "code_verifier": "verifier", "redirect_uri": "https://app/cb", "client_id": "id"} {
+		if got := form.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if ts.auths[0] != "" {
+		t.Errorf("Authorization = %q, want none", ts.auths[0])
+	}
+}
+
+func TestRefreshKeepsRefreshToken(t *testing.T) {
+	ts := newTokenServer(`{"access_token":"at2"}`)
+	defer ts.Close()
+
+	c := &Config{ClientID: "id", TokenURL: ts.URL}
+	token, err := c.Refresh(nil, "rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "at2" || token.RefreshToken != "rt" {
+		t.Errorf("token = %+v", token)
+	}
+	if got := ts.forms[0].Get("refresh_token"); got != "rt" {
+		t.Errorf("refresh_token = %q", got)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	ts := newTokenServer(`{"error":"invalid_client","error_description":"unknown client"}`)
+	defer ts.Close()
+
+	c := &Config{ClientID: "id", TokenURL: ts.URL}
+	_, err := c.ClientCredentials(nil)
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if oauthErr.StatusCode != http.StatusBadRequest || oauthErr.Code != "invalid_client" || oauthErr.Description != "unknown client" {
+		t.Errorf("err = %+v", oauthErr)
+	}
+}
+
+func TestFormResponse(t *testing.T) {
+	token, err := parseTokenResponse(http.StatusOK, "application/x-www-form-urlencoded; charset=utf-8", []byte("access_token=at&scope=repo&token_type=bearer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "at" || token.Scope != "repo" || !token.Expiry.IsZero() {
+		t.Errorf("token = %+v", token)
+	}
+}
+
+func TestCachingTokenSource(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer setTime(now)()
+	ts := newTokenServer(
+		`{"access_token":"at1","expires_in":3600}`,
+		`{"access_token":"at2","expires_in":3600}`,
+	)
+	defer ts.Close()
+
+	c := &Config{ClientID: "id", ClientSecret: "secret", TokenURL: ts.URL}
+	s := c.ClientCredentialsSource(nil)
+	var seen []string
+	s.OnToken = func(t *Token) { seen = append(seen, t.AccessToken) }
+
+	for i := 0; i < 2; i++ {
+		token, err := s.Token()
+		if err != nil || token.AccessToken != "at1" {
+			t.Fatalf("Token() = %+v, %v, want at1", token, err)
+		}
+	}
+	if len(ts.forms) != 1 {
+		t.Errorf("%d token requests, want 1", len(ts.forms))
+	}
+
+	// due once within RefreshBefore of expiry, ahead of it
+	setTime(now.Add(3600*time.Second - DefaultRefreshBefore))
+	token, err := s.Token()
+	if err != nil || token.AccessToken != "at2" {
+		t.Fatalf("Token() = %+v, %v, want at2", token, err)
+	}
+	if strings.Join(seen, ",") != "at1,at2" {
+		t.Errorf("OnToken saw %v", seen)
+	}
+}
+
+func TestCachingTokenSourceRefreshFailure(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer setTime(now)()
+	ts := newTokenServer(
+		`{"error":"temporarily_unavailable"}`,
+		`{"error":"invalid_grant"}`,
+		`{"access_token":"at2","expires_in":60}`,
+	)
+	defer ts.Close()
+
+	c := &Config{ClientID: "id", TokenURL: ts.URL}
+	s := c.TokenSource(nil, &Token{AccessToken: "at1", RefreshToken: "rt", Expiry: now.Add(30 * time.Second)})
+
+	// the current token is still good while its refresh fails
+	token, err := s.Token()
+	if err != nil || token.AccessToken != "at1" {
+		t.Fatalf("Token() = %+v, %v, want at1", token, err)
+	}
+	setTime(now.Add(time.Minute))
+	if token, err := s.Token(); err == nil {
+		t.Fatalf("Token() = %+v, want error once expired", token)
+	}
+	token, err = s.Token()
+	if err != nil || token.AccessToken != "at2" || token.RefreshToken != "rt" {
+		t.Fatalf("Token() = %+v, %v, want at2 keeping rt", token, err)
+	}
+}
+
+type staticSource string
+
+func (s staticSource) Token() (*Token, error) {
+	return &Token{AccessToken: string(s)}, nil
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := NewClient(staticSource("at")).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	p := make([]byte, 64)
+	n, _ := resp.Body.Read(p)
+	if got := string(p[:n]); got != "Bearer at" {
+		t.Errorf("server saw Authorization %q", got)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Errorf("request was modified")
+	}
+}
@@ -0,0 +1,145 @@
+package restpoll
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"engines/github.com.garyburd.go-oauth/oauth"
+)
+
+const (
+	maxBodyBytes = 8 << 20
+)
+
+// Auth carries either OAuth 1.0a consumer and token credentials or a bearer
+// token. The bearer token wins when both are set.
+type Auth struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	Token          string
+	TokenSecret    string
+	Bearer         string
+}
+
+// RateLimit is the budget the source reported in its response headers.
+// Present is false when the source sent no rate limit headers.
+type RateLimit struct {
+	Present   bool
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+type HTTPStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (err HTTPStatusError) Error() string {
+	return "restpoll: status=" + strconv.Itoa(err.StatusCode) + " " + err.Message
+}
+
+type Client struct {
+	client *http.Client
+}
+
+func New(timeout time.Duration) *Client {
+	client := new(Client)
+	client.client = &http.Client{Timeout: timeout}
+	return client
+}
+
+// Get issues a signed GET for urlStr with params as the query string. urlStr
+// must not contain a query string.
+func (client *Client) Get(auth Auth, urlStr string, params url.Values) ([]byte, RateLimit, error) {
+	var resp *http.Response
+	var err error
+
+	if auth.Bearer != "" {
+		var req *http.Request
+		req, err = http.NewRequest("GET", urlStr, nil)
+		if err != nil {
+			return nil, RateLimit{}, err
+		}
+		req.URL.RawQuery = params.Encode()
+		req.Header.Set("Authorization", "Bearer "+auth.Bearer)
+		resp, err = client.client.Do(req)
+	} else {
+		oauth_client := oauth.Client{Credentials: oauth.Credentials{Token: auth.ConsumerKey, Secret: auth.ConsumerSecret}}
+		resp, err = oauth_client.Get(client.client, &oauth.Credentials{Token: auth.Token, Secret: auth.TokenSecret}, urlStr, params)
+	}
+	if err != nil {
+		return nil, RateLimit{}, err
+	}
+	defer resp.Body.Close()
+
+	now := time.Now()
+	rate_limit := ParseRateLimit(resp.Header, now)
+
+	if resp.StatusCode != http.StatusOK {
+		p, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		retry_after := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+		if retry_after == 0 && resp.StatusCode == http.StatusTooManyRequests && rate_limit.Present {
+			retry_after = rate_limit.Reset.Sub(now)
+		}
+		return nil, rate_limit, HTTPStatusError{StatusCode: resp.StatusCode, RetryAfter: retry_after, Message: string(p)}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	return body, rate_limit, err
+}
+
+// ParseRateLimit understands the X-Rate-Limit-* headers used by Twitter and
+// the X-RateLimit-* variant used by most other APIs. Reset is either epoch
+// seconds or, for small values, seconds from now.
+func ParseRateLimit(header http.Header, now time.Time) RateLimit {
+	var rate_limit RateLimit
+
+	remaining := firstHeader(header, "X-Rate-Limit-Remaining", "X-RateLimit-Remaining")
+	if remaining == "" {
+		return rate_limit
+	}
+	n, err := strconv.Atoi(remaining)
+	if err != nil {
+		return rate_limit
+	}
+	rate_limit.Present = true
+	rate_limit.Remaining = n
+	rate_limit.Limit, _ = strconv.Atoi(firstHeader(header, "X-Rate-Limit-Limit", "X-RateLimit-Limit"))
+
+	reset, err := strconv.ParseInt(firstHeader(header, "X-Rate-Limit-Reset", "X-RateLimit-Reset"), 10, 64)
+	if err == nil {
+		if reset > 1000000000 {
+			rate_limit.Reset = time.Unix(reset, 0)
+		} else {
+			rate_limit.Reset = now.Add(time.Duration(reset) * time.Second)
+		}
+	}
+	return rate_limit
+}
+
+func firstHeader(header http.Header, names ...string) string {
+	for _, name := range names {
+		if v := strings.TrimSpace(header.Get(name)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
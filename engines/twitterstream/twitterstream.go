@@ -2,7 +2,10 @@ package twitterstream
 
 import (
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"strings"
 	"sync"
@@ -13,6 +16,7 @@ import (
 
 const (
 	FilterUrl = "https://stream.twitter.com/1.1/statuses/filter.json"
+	VerifyUrl = "https://api.twitter.com/1.1/account/verify_credentials.json"
 )
 
 type State int
@@ -25,6 +29,17 @@ const (
 	CLOSING
 )
 
+// UnmarshalError is returned for a line that was read from the stream but
+// could not be decoded, the stream itself is still usable.
+type UnmarshalError struct {
+	Rawsource []byte
+	Err       error
+}
+
+func (err UnmarshalError) Error() string {
+	return "twitterstream: cannot unmarshal: " + err.Err.Error()
+}
+
 type TwitterStream struct {
 	*Stream
 	rwlock sync.RWMutex
@@ -52,10 +67,11 @@ func (stream *TwitterStream) UnmarshalNext() (*TweetResponse, error) {
 	if err != nil {
 		return nil, err
 	}
-	t.Rawsource = tweet
+	// Next() overwrites its buffer on the following call
+	t.Rawsource = append([]byte(nil), tweet...)
 
-	if err := json.Unmarshal(tweet, &t.Tweet); err != nil {
-		return nil, err
+	if err := json.Unmarshal(t.Rawsource, &t.Tweet); err != nil {
+		return nil, UnmarshalError{Rawsource: t.Rawsource, Err: err}
 	}
 	if t.Tweet.RetweetedStatus.User.IdString != "" {
 		t.RetweetUserId = t.Tweet.RetweetedStatus.User.Id
@@ -70,6 +86,16 @@ func (stream *TwitterStream) UnmarshalNext() (*TweetResponse, error) {
 		}
 	}
 
+	if t.Tweet.QuotedStatus.User.IdString != "" {
+		t.QuotedUserId = t.Tweet.QuotedStatus.User.Id
+		t.QuotedUserIdStr = t.Tweet.QuotedStatus.User.IdString
+	}
+
+	t.AuthorUserId = t.Tweet.User.Id
+	t.AuthorUserIdStr = t.Tweet.User.IdString
+	t.ReplyUserId = t.Tweet.InReplyToUserId
+	t.ReplyUserIdStr = t.Tweet.InReplyToUserIdStr
+
 	if t.Tweet.InReplyToUserIdStr != "" {
 		t.ScanUserId = t.Tweet.InReplyToUserId
 		t.ScanUserIdStr = t.Tweet.InReplyToUserIdStr
@@ -94,6 +120,12 @@ type RetweetedStatus struct {
 	Entities `json:"entities"`
 }
 
+type QuotedStatus struct {
+	Id       int64  `json:"id"`
+	IdString string `json:"id_str"`
+	User     User   `json:"user"`
+}
+
 type UserMention struct {
 	Id       int64  `json:"id"`
 	IdString string `json:"id_str"`
@@ -114,6 +146,7 @@ type UnmarshalledTweet struct {
 	InReplyToUserIdStr string `json:"in_reply_to_user_id_str"`
 
 	RetweetedStatus RetweetedStatus `json:"retweeted_status"`
+	QuotedStatus    QuotedStatus    `json:"quoted_status"`
 	Entities        `json:"entities"`
 }
 
@@ -127,9 +160,18 @@ type TweetResponse struct {
 	ScanUserId    int64
 	ScanUserIdStr string
 
+	AuthorUserId    int64
+	AuthorUserIdStr string
+
+	ReplyUserId    int64
+	ReplyUserIdStr string
+
 	RetweetUserId    int64
 	RetweetUserIdStr string
 
+	QuotedUserId    int64
+	QuotedUserIdStr string
+
 	UserMentions []TweetUserMention
 	Rawsource    []byte
 }
@@ -172,6 +214,35 @@ func (stream *TwitterStream) Open(token string, token_secret string, oauth_token
 	return err
 }
 
+// VerifyCredentials asks the API whether it accepts the credentials, its
+// refusal is an HTTPStatusError.
+func VerifyCredentials(token string, token_secret string, oauth_token string, oauth_token_secret string) error {
+	client := oauth.Client{
+		Credentials: oauth.Credentials{
+			Token:  token,
+			Secret: token_secret,
+		},
+	}
+	resp, err := client.Get(
+		&http.Client{Timeout: 30 * time.Second},
+		&oauth.Credentials{
+			Token:  oauth_token,
+			Secret: oauth_token_secret,
+		},
+		VerifyUrl,
+		url.Values{"skip_status": {"true"}},
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		p, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return HTTPStatusError{StatusCode: resp.StatusCode, Message: string(p)}
+	}
+	return nil
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -1,97 +1,313 @@
 package account_entry
 
 import (
+	"strings"
 	"sync"
 	"time"
 
 	"realtime/logger"
 )
 
-type AccountState int
+// UpdateKind is a set of the kinds of content an update can be.
+type UpdateKind uint8
 
 const (
-	UNMONITORED AccountState = iota
-	MONITORED
+	KIND_POST UpdateKind = 1 << iota
+	KIND_REPLY
+	KIND_RETWEET
+	KIND_MENTION
+	KIND_QUOTE
+
+	KIND_NONE           UpdateKind = 0
+	DEFAULT_KIND_POLICY UpdateKind = KIND_POST | KIND_REPLY
 )
 
+var kindNames = []struct {
+	kind UpdateKind
+	name string
+}{
+	{KIND_POST, "post"},
+	{KIND_REPLY, "reply"},
+	{KIND_RETWEET, "retweet"},
+	{KIND_MENTION, "mention"},
+	{KIND_QUOTE, "quote"},
+}
+
+func (kinds UpdateKind) Names() []string {
+	var names []string
+	for _, k := range kindNames {
+		if kinds&k.kind != 0 {
+			names = append(names, k.name)
+		}
+	}
+	return names
+}
+
+// ParseKinds parses a comma separated list of kind names.
+func ParseKinds(list string) (UpdateKind, bool) {
+	kinds := KIND_NONE
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, k := range kindNames {
+			if k.name == name {
+				kinds |= k.kind
+				found = true
+				break
+			}
+		}
+		if !found {
+			return KIND_NONE, false
+		}
+	}
+	return kinds, true
+}
+
+// Watcher is told when an account gets new content its default scanner has
+// not consumed and when that scanner consumes it. It is called with the
+// entry lock held and must not call back into the entry.
+type Watcher interface {
+	Updated(account_id string, sequence uint64)
+	Scanned(account_id string)
+}
+
 type Entry struct {
-	account_id     string
-	last_scan_dt   int64
-	last_update_dt int64
-	scanner_seen   bool
-	state          AccountState
-	logger         logger.Logger
-	rwlock         sync.RWMutex
+	account_id      string
+	last_scan_dt    int64
+	last_update_dt  int64
+	last_scan_seq   uint64
+	last_update_seq uint64
+	sequence        *Sequence
+	watcher         Watcher
+	last_error      string
+	last_error_dt   int64
+	cursor          string
+	pending_kinds   UpdateKind
+	kind_policy     UpdateKind
+	scanner_seen    bool
+	consumers       []consumerCursor
+	state           AccountState
+	state_reason    string
+	state_dt        int64
+	priority        int
+	labels          map[string]string
+	logger          logger.Logger
+	rwlock          sync.RWMutex
 }
 
 func (h *Entry) AccountId() string {
 	return h.account_id
 }
 
-func (h *Entry) SetState(state AccountState) {
-	h.rwlock.Lock()
-	defer h.rwlock.Unlock()
+func (h *Entry) ScannerSeen() bool {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
 
-	h.state = state
+	return h.scanner_seen
 }
 
-func (h *Entry) State() AccountState {
+func (h *Entry) IsUpdated() bool {
 	h.rwlock.RLock()
 	defer h.rwlock.RUnlock()
 
-	return h.state
+	return h.last_update_seq > h.last_scan_seq
 }
 
-func (h *Entry) ScannerSeen() bool {
+// UpdatedSince reports whether new content arrived after sequence since.
+func (h *Entry) UpdatedSince(since uint64) bool {
 	h.rwlock.RLock()
 	defer h.rwlock.RUnlock()
 
-	return h.scanner_seen
+	return h.last_update_seq > since
 }
 
-func (h *Entry) IsUpdated() bool {
+// LastScanDt is when any consumer last scanned the account.
+func (h *Entry) LastScanDt() time.Time {
 	h.rwlock.RLock()
 	defer h.rwlock.RUnlock()
 
-	if h.last_update_dt >= h.last_scan_dt {
-		return true
-	}
-	return false
+	return time.Unix(h.last_scan_dt, 0)
+}
+
+func (h *Entry) LastUpdateSeq() uint64 {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.last_update_seq
+}
+
+func (h *Entry) LastScanSeq() uint64 {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.last_scan_seq
 }
 
 func (h *Entry) SetLastUpdate() bool {
+	return h.SetLastUpdateKind(KIND_POST)
+}
+
+// SetLastUpdateKind records an update of the given kind. The update is
+// always reported as pending but only counts as new content when the
+// account's policy includes its kind.
+func (h *Entry) SetLastUpdateKind(kind UpdateKind) bool {
+	_, counted := h.RecordUpdate(kind)
+	return counted
+}
+
+// RecordUpdate is SetLastUpdateKind that also returns the sequence the
+// update was recorded under, zero when it did not count as new content.
+func (h *Entry) RecordUpdate(kind UpdateKind) (uint64, bool) {
 	h.rwlock.Lock()
 	defer h.rwlock.Unlock()
 
-	last_update := &h.last_update_dt
+	h.pending_kinds |= kind
+	for i := range h.consumers {
+		h.consumers[i].pending_kinds |= kind
+	}
+	if h.kind_policy&kind == 0 {
+		h.logger.Debugf("not counting %v update as new content", kind.Names())
+		return 0, false
+	}
+
+	last_update := h.last_update_seq
 	h.last_update_dt = int64(time.Now().Unix())
+	h.last_update_seq = h.sequence.Next()
+	if h.watcher != nil && last_update <= h.last_scan_seq {
+		h.watcher.Updated(h.account_id, h.last_update_seq)
+	}
 
-	h.logger.Debugf("setting last content date from %d to %d", last_update, h.last_update_dt)
-	return true
+	h.logger.Debugf("setting last content sequence from %d to %d", last_update, h.last_update_seq)
+	return h.last_update_seq, true
+}
+
+func (h *Entry) PendingKinds() UpdateKind {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.pending_kinds
+}
+
+func (h *Entry) SetKindPolicy(policy UpdateKind) {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	h.kind_policy = policy
+}
+
+func (h *Entry) KindPolicy() UpdateKind {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.kind_policy
+}
+
+func (h *Entry) SetLastError(last_error string) {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	if last_error == "" {
+		h.last_error = ""
+		h.last_error_dt = 0
+		return
+	}
+	h.last_error = last_error
+	h.last_error_dt = int64(time.Now().Unix())
+	h.logger.Debugf("setting last error to '%s'", last_error)
+}
+
+func (h *Entry) LastError() (string, int64) {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.last_error, h.last_error_dt
+}
+
+// SetPriority ranks the account for admission when its property is over
+// capacity, higher goes first.
+func (h *Entry) SetPriority(priority int) {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	h.priority = priority
+}
+
+func (h *Entry) Priority() int {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.priority
+}
+
+// SetCursor stores the source position (e.g. a since_id) a polling
+// connector has read up to for this account.
+func (h *Entry) SetCursor(cursor string) {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	h.cursor = cursor
+}
+
+func (h *Entry) Cursor() string {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.cursor
 }
 
 func (h *Entry) SetLastScan() bool {
+	h.Scan()
+	return true
+}
+
+// Scan marks everything up to now as consumed by the scanner. It returns the
+// sequence assigned to the scan together with whether new content and which
+// kinds were consumed by it.
+func (h *Entry) Scan() (uint64, bool, UpdateKind) {
 	h.rwlock.Lock()
 	defer h.rwlock.Unlock()
 
-	last_scan := &h.last_scan_dt
-	h.last_scan_dt = int64(time.Now().Unix())
+	last_scan := h.last_scan_seq
+	updated := h.last_update_seq > h.last_scan_seq
+	pending := h.pending_kinds
+
+	h.touchScan()
+	h.last_scan_seq = h.sequence.Next()
 
 	if h.scanner_seen == false && h.state == MONITORED {
 		h.scanner_seen = true
 	}
+	h.pending_kinds = KIND_NONE
+	if h.watcher != nil && updated {
+		h.watcher.Scanned(h.account_id)
+	}
 
-	h.logger.Debugf("setting last scan date from %d to %d", last_scan, h.last_scan_dt)
-	h.logger.Debugf("setting last content date from %d to %d", 100, 10)
-	return true
+	h.logger.Debugf("setting last scan sequence from %d to %d", last_scan, h.last_scan_seq)
+	return h.last_scan_seq, updated, pending
+}
+
+// touchScan records when any consumer last scanned the account. The caller
+// must hold the lock.
+func (h *Entry) touchScan() {
+	h.last_scan_dt = int64(time.Now().Unix())
 }
 
-func New(account_id string) Entry {
+func New(account_id string, sequence *Sequence, watcher Watcher) Entry {
 	var account_entry Entry
 
+	if sequence == nil {
+		sequence = new(Sequence)
+	}
 	account_entry.account_id = account_id
-	account_entry.state = UNMONITORED
+	account_entry.sequence = sequence
+	account_entry.watcher = watcher
+	account_entry.state = PENDING
+	account_entry.state_dt = int64(time.Now().Unix())
 	account_entry.scanner_seen = false
+	account_entry.kind_policy = DEFAULT_KIND_POLICY
 	account_entry.logger.Logprefix = "account " + account_id
 
 	return account_entry
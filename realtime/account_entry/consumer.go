@@ -0,0 +1,124 @@
+package account_entry
+
+// DEFAULT_CONSUMER is the scanner that does not name itself, its cursor is
+// kept in the entry itself. Named consumers get a cursor the first time they
+// scan an account, so accounts only one consumer cares about stay small.
+const DEFAULT_CONSUMER uint32 = 0
+
+type consumerCursor struct {
+	consumer      uint32
+	last_scan_seq uint64
+	pending_kinds UpdateKind
+	scanner_seen  bool
+}
+
+// cursor returns the cursor of a named consumer, or nil if it never scanned
+// this account. The caller must hold the lock.
+func (h *Entry) cursorOf(consumer uint32) *consumerCursor {
+	for i := range h.consumers {
+		if h.consumers[i].consumer == consumer {
+			return &h.consumers[i]
+		}
+	}
+	return nil
+}
+
+func (h *Entry) ScannerSeenBy(consumer uint32) bool {
+	if consumer == DEFAULT_CONSUMER {
+		return h.ScannerSeen()
+	}
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	cursor := h.cursorOf(consumer)
+	return cursor != nil && cursor.scanner_seen
+}
+
+// IsUpdatedFor reports new content since the consumer's last scan. A
+// consumer that never scanned the account has not seen anything yet.
+func (h *Entry) IsUpdatedFor(consumer uint32) bool {
+	if consumer == DEFAULT_CONSUMER {
+		return h.IsUpdated()
+	}
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	cursor := h.cursorOf(consumer)
+	if cursor == nil {
+		return h.last_update_seq > 0
+	}
+	return h.last_update_seq > cursor.last_scan_seq
+}
+
+// LastScanSeqFor is zero for a consumer that never scanned the account.
+func (h *Entry) LastScanSeqFor(consumer uint32) uint64 {
+	if consumer == DEFAULT_CONSUMER {
+		return h.LastScanSeq()
+	}
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	cursor := h.cursorOf(consumer)
+	if cursor == nil {
+		return 0
+	}
+	return cursor.last_scan_seq
+}
+
+func (h *Entry) PendingKindsFor(consumer uint32) UpdateKind {
+	if consumer == DEFAULT_CONSUMER {
+		return h.PendingKinds()
+	}
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	cursor := h.cursorOf(consumer)
+	if cursor == nil {
+		return KIND_NONE
+	}
+	return cursor.pending_kinds
+}
+
+// ScanAs is Scan for a named consumer, it leaves the cursors of every other
+// consumer untouched.
+func (h *Entry) ScanAs(consumer uint32) (uint64, bool, UpdateKind) {
+	if consumer == DEFAULT_CONSUMER {
+		return h.Scan()
+	}
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	cursor := h.cursorOf(consumer)
+	if cursor == nil {
+		h.consumers = append(h.consumers, consumerCursor{consumer: consumer})
+		cursor = &h.consumers[len(h.consumers)-1]
+	}
+	updated := h.last_update_seq > cursor.last_scan_seq
+	pending := cursor.pending_kinds
+
+	h.touchScan()
+	cursor.last_scan_seq = h.sequence.Next()
+	cursor.pending_kinds = KIND_NONE
+	if cursor.scanner_seen == false && h.state == MONITORED {
+		cursor.scanner_seen = true
+	}
+	return cursor.last_scan_seq, updated, pending
+}
+
+// DropConsumer forgets the cursor of a consumer that was removed.
+func (h *Entry) DropConsumer(consumer uint32) {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	for i := range h.consumers {
+		if h.consumers[i].consumer == consumer {
+			last := len(h.consumers) - 1
+			h.consumers[i] = h.consumers[last]
+			h.consumers = h.consumers[:last]
+			if len(h.consumers) == 0 {
+				h.consumers = nil
+			}
+			return
+		}
+	}
+}
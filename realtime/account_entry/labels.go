@@ -0,0 +1,38 @@
+package account_entry
+
+// SetLabel sets a label of the account, an empty value removes it. It
+// returns the previous value.
+func (h *Entry) SetLabel(key string, value string) string {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	previous := h.labels[key]
+	if value == "" {
+		delete(h.labels, key)
+		return previous
+	}
+	if h.labels == nil {
+		h.labels = make(map[string]string)
+	}
+	h.labels[key] = value
+	return previous
+}
+
+func (h *Entry) Label(key string) string {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.labels[key]
+}
+
+// Labels returns a copy of the labels of the account.
+func (h *Entry) Labels() map[string]string {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	labels := make(map[string]string, len(h.labels))
+	for k, v := range h.labels {
+		labels[k] = v
+	}
+	return labels
+}
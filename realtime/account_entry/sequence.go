@@ -0,0 +1,20 @@
+package account_entry
+
+import (
+	"sync/atomic"
+)
+
+// Sequence hands out store-wide, strictly increasing numbers so updates and
+// scans of an account can be ordered exactly, no matter how close together
+// they happen. The first number handed out is 1.
+type Sequence struct {
+	last uint64
+}
+
+func (s *Sequence) Next() uint64 {
+	return atomic.AddUint64(&s.last, 1)
+}
+
+func (s *Sequence) Last() uint64 {
+	return atomic.LoadUint64(&s.last)
+}
@@ -0,0 +1,123 @@
+package account_entry
+
+import (
+	"time"
+)
+
+// Snapshot is the part of an entry that survives a move to another store.
+// Sequences are local to a store, so a snapshot only records whether each
+// consumer has content it did not scan yet.
+type Snapshot struct {
+	AccountId   string
+	State       AccountState
+	StateReason string
+	StateDt     time.Time
+	LastScanDt  time.Time
+	LastUpdate  time.Time
+	Priority    int
+	KindPolicy  UpdateKind
+	Labels      map[string]string
+	Updated     bool
+	// Cursor is the source position a polling connector has read up to.
+	Cursor string
+	// Consumers maps named consumers with a cursor to whether they have
+	// unscanned content.
+	Consumers map[uint32]bool
+}
+
+func (h *Entry) Snapshot() Snapshot {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	snapshot := Snapshot{
+		AccountId:   h.account_id,
+		State:       h.state,
+		StateReason: h.state_reason,
+		StateDt:     time.Unix(h.state_dt, 0),
+		LastScanDt:  time.Unix(h.last_scan_dt, 0),
+		Priority:    h.priority,
+		KindPolicy:  h.kind_policy,
+		Labels:      make(map[string]string, len(h.labels)),
+		Updated:     h.last_update_seq > h.last_scan_seq,
+		Cursor:      h.cursor,
+		Consumers:   make(map[uint32]bool, len(h.consumers)),
+	}
+	if h.last_update_dt != 0 {
+		snapshot.LastUpdate = time.Unix(h.last_update_dt, 0)
+	}
+	for k, v := range h.labels {
+		snapshot.Labels[k] = v
+	}
+	for _, cursor := range h.consumers {
+		snapshot.Consumers[cursor.consumer] = h.last_update_seq > cursor.last_scan_seq
+	}
+	return snapshot
+}
+
+// Restore overwrites the entry with a snapshot taken elsewhere. Only the
+// dormant state is taken from the snapshot. An account its connector follows
+// already keeps its state, as no restart follows it again, any other account
+// is pending until its connector follows it. Labels are left to the store
+// which indexes them.
+func (h *Entry) Restore(snapshot Snapshot) {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	was_updated := h.last_update_seq > h.last_scan_seq
+	following := h.state == MONITORED || h.state == DEGRADED
+	if snapshot.State == DORMANT {
+		h.state = DORMANT
+		h.state_reason = snapshot.StateReason
+		h.state_dt = int64(time.Now().Unix())
+	} else if !following {
+		h.state = PENDING
+		h.state_reason = "imported"
+		h.state_dt = int64(time.Now().Unix())
+	}
+	h.last_scan_dt = snapshot.LastScanDt.Unix()
+	h.last_update_dt = 0
+	if !snapshot.LastUpdate.IsZero() {
+		h.last_update_dt = snapshot.LastUpdate.Unix()
+	}
+	h.priority = snapshot.Priority
+	if snapshot.KindPolicy != KIND_NONE {
+		h.kind_policy = snapshot.KindPolicy
+	}
+	h.pending_kinds = KIND_NONE
+	// a snapshot without a cursor leaves the one read so far
+	if snapshot.Cursor != "" {
+		h.cursor = snapshot.Cursor
+	}
+
+	// consumers with unscanned content scanned before the update, the
+	// others after it
+	updated := snapshot.Updated
+	for _, consumer_updated := range snapshot.Consumers {
+		updated = updated || consumer_updated
+	}
+	before := h.sequence.Next()
+	h.last_update_seq = 0
+	if updated {
+		h.last_update_seq = h.sequence.Next()
+	}
+	after := h.sequence.Next()
+
+	h.last_scan_seq = after
+	if snapshot.Updated {
+		h.last_scan_seq = before
+	}
+	if h.watcher != nil && was_updated {
+		h.watcher.Scanned(h.account_id)
+	}
+	if h.watcher != nil && snapshot.Updated {
+		h.watcher.Updated(h.account_id, h.last_update_seq)
+	}
+	h.consumers = nil
+	for consumer, consumer_updated := range snapshot.Consumers {
+		cursor := consumerCursor{consumer: consumer, last_scan_seq: after}
+		if consumer_updated {
+			cursor.last_scan_seq = before
+		}
+		h.consumers = append(h.consumers, cursor)
+	}
+}
@@ -0,0 +1,135 @@
+package account_entry
+
+import (
+	"time"
+)
+
+type AccountState int
+
+const (
+	// PENDING accounts are stored but their connector does not follow them
+	// yet.
+	PENDING AccountState = iota
+	MONITORED
+	// DEGRADED accounts were followed until their connector lost the source,
+	// updates may be missed until it recovers.
+	DEGRADED
+	// REJECTED accounts were refused by the source, e.g. unknown or
+	// protected.
+	REJECTED
+	// OVER_CAPACITY accounts do not fit in what the connector can follow.
+	OVER_CAPACITY
+	// DORMANT accounts no scanner asked about for a while are kept in the
+	// store but no longer followed, only Wake takes an account out of it.
+	DORMANT
+)
+
+var stateNames = map[AccountState]string{
+	PENDING:       "pending",
+	MONITORED:     "monitored",
+	DEGRADED:      "degraded",
+	REJECTED:      "rejected",
+	OVER_CAPACITY: "over capacity",
+	DORMANT:       "dormant",
+}
+
+func (state AccountState) String() string {
+	name, present := stateNames[state]
+	if !present {
+		return "unknown"
+	}
+	return name
+}
+
+func (h *Entry) SetState(state AccountState) {
+	h.SetStateReason(state, "")
+}
+
+// SetStateReason moves the account to state and records why. Connectors
+// cannot move an account out of DORMANT or OVER_CAPACITY, the store decides
+// on those.
+func (h *Entry) SetStateReason(state AccountState, reason string) {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	if h.state == DORMANT || h.state == OVER_CAPACITY {
+		return
+	}
+	h.transition(state, reason)
+}
+
+// transition records a change of state or reason. The caller must hold the
+// lock.
+func (h *Entry) transition(state AccountState, reason string) {
+	if h.state == state && h.state_reason == reason {
+		return
+	}
+	h.logger.Debugf("state %s -> %s '%s'", h.state, state, reason)
+	h.state = state
+	h.state_reason = reason
+	h.state_dt = int64(time.Now().Unix())
+}
+
+func (h *Entry) Sleep() {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	h.transition(DORMANT, "not scanned")
+}
+
+// SleepIdle makes the account DORMANT unless it was scanned at or after
+// idle_since, the check and the transition are one step so a scan cannot
+// come between them.
+func (h *Entry) SleepIdle(idle_since time.Time) bool {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	if h.state == DORMANT || h.last_scan_dt >= idle_since.Unix() {
+		return false
+	}
+	h.transition(DORMANT, "not scanned")
+	return true
+}
+
+// Wake takes a dormant account back, it is pending until its connector
+// follows it again.
+func (h *Entry) Wake() {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	if h.state == DORMANT {
+		h.transition(PENDING, "woken by scanner")
+	}
+}
+
+func (h *Entry) State() AccountState {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.state
+}
+
+// StateInfo returns the state with the reason and time of the last
+// transition.
+func (h *Entry) StateInfo() (AccountState, string, time.Time) {
+	h.rwlock.RLock()
+	defer h.rwlock.RUnlock()
+
+	return h.state, h.state_reason, time.Unix(h.state_dt, 0)
+}
+
+// Admit moves an account in or out of OVER_CAPACITY, an admitted account is
+// pending until its connector follows it.
+func (h *Entry) Admit(admitted bool, reason string) {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+
+	if h.state == DORMANT {
+		return
+	}
+	if !admitted {
+		h.transition(OVER_CAPACITY, reason)
+	} else if h.state == OVER_CAPACITY {
+		h.transition(PENDING, reason)
+	}
+}
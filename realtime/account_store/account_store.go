@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"realtime/account_entry"
+	"realtime/itembuffer"
 )
 
 type Property string
@@ -12,6 +13,9 @@ type Property string
 const (
 	TWITTER_STREAM Property = "twitterstream"
 	FAKE_STREAM    Property = "fakestream"
+	FEED_POLL      Property = "feedpoll"
+	WEBHOOK_INGEST Property = "webhook"
+	REST_POLL      Property = "restpoll"
 )
 
 type Store struct {
@@ -20,7 +24,16 @@ type Store struct {
 	account_slice     []string
 	restart_on_change bool
 	restart           bool
+	admit_pending     bool
+	follow_all        bool
 	count             int64
+	sequence          account_entry.Sequence
+	consumers         consumers
+	updates           *updateIndex
+	items             *itembuffer.Buffer
+	lifecycle         LifecyclePolicy
+	capacity          int
+	labels            labelIndex
 	rwlock            sync.RWMutex
 }
 
@@ -30,6 +43,7 @@ func New(restart_on_change bool) *Store {
 	account_store.account_entries = make(map[string]*account_entry.Entry)
 	account_store.restart_on_change = restart_on_change
 	account_store.restart = false
+	account_store.updates = newUpdateIndex()
 
 	syslog.Debugf("new store created restart on change: %t", restart_on_change)
 	return account_store
@@ -46,14 +60,26 @@ func (account_store *Store) AddAccountEntry(account_id string) *account_entry.En
 	if present {
 		return mc
 	}
-	account_entry := account_entry.New(account_id)
+	account_entry := account_store.newEntry(account_id)
 	account_entry.SetLastScan()
 
-	Store[account_id] = &account_entry
+	if account_store.capacity > 0 {
+		account_store.readmit()
+		return account_entry
+	}
 	account_store.account_slice = append(account_store.account_slice, account_id)
 	account_store.restart = true
+	account_store.followed(account_entry)
+	return account_entry
+}
+
+// newEntry stores a new entry without following it. The caller must hold
+// the write lock.
+func (account_store *Store) newEntry(account_id string) *account_entry.Entry {
+	entry := account_entry.New(account_id, &account_store.sequence, account_store.updates)
+	account_store.account_entries[account_id] = &entry
 	account_store.count += 1
-	return &account_entry
+	return &entry
 }
 
 func (account_store *Store) RemoveAccountEntry(account_id string) *account_entry.Entry {
@@ -61,14 +87,12 @@ func (account_store *Store) RemoveAccountEntry(account_id string) *account_entry
 	account_store.rwlock.Lock()
 	defer account_store.rwlock.Unlock()
 
-	Store := account_store.account_entries
-
-	mc, present := Store[account_id]
+	mc, present := account_store.account_entries[account_id]
 	if !present {
 		return nil
 	}
 
-	delete(Store, account_id)
+	account_store.forget(account_id, mc)
 
 	var new_slice []string
 	for _, str := range account_store.account_slice {
@@ -81,10 +105,45 @@ func (account_store *Store) RemoveAccountEntry(account_id string) *account_entry
 	account_store.account_slice = new_slice
 
 	account_store.restart = true
-	account_store.count -= 1
+	account_store.readmit()
 	return mc
 }
 
+// forget drops an entry and everything kept about it but leaves the account
+// slice alone. The caller must hold the write lock.
+func (account_store *Store) forget(account_id string, entry *account_entry.Entry) {
+	delete(account_store.account_entries, account_id)
+	account_store.unlabel(account_id, entry.Labels())
+	account_store.updates.remove(account_id)
+	if account_store.items != nil {
+		account_store.items.Remove(itembuffer.Key(string(account_store.Property), account_id))
+	}
+	account_store.count -= 1
+}
+
+// SetFollowAll is called by connectors that follow every account as soon as
+// it is stored, as ingests do. While set, accounts added to the account slice
+// are MONITORED right away, setting it marks those followed already.
+func (account_store *Store) SetFollowAll(follow_all bool) {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	account_store.follow_all = follow_all
+	for _, account_id := range account_store.account_slice {
+		if entry, present := account_store.account_entries[account_id]; present {
+			account_store.followed(entry)
+		}
+	}
+}
+
+// followed is called for an entry in the account slice. The caller must hold
+// the write lock.
+func (account_store *Store) followed(entry *account_entry.Entry) {
+	if account_store.follow_all && entry.State() != account_entry.MONITORED {
+		entry.SetState(account_entry.MONITORED)
+	}
+}
+
 func (account_store *Store) Count() int64 {
 	account_store.rwlock.RLock()
 	defer account_store.rwlock.RUnlock()
@@ -112,6 +171,12 @@ func (account_store *Store) SetRestart(state bool) {
 	account_store.restart = state
 }
 
+// Sequence returns the last sequence handed out to any update or scan.
+func (account_store *Store) Sequence() uint64 {
+	return account_store.sequence.Last()
+}
+
+// AccountSlice lists the accounts to follow, dormant accounts are left out.
 func (account_store *Store) AccountSlice() []string {
 	account_store.rwlock.RLock()
 	defer account_store.rwlock.RUnlock()
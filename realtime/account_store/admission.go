@@ -0,0 +1,150 @@
+package account_store
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"realtime/account_entry"
+)
+
+// ParseCapacities parses "<property>=<accounts>" pairs separated by commas,
+// e.g. "twitterstream=5000". Zero means no limit.
+func ParseCapacities(value string) (map[Property]int, error) {
+	capacities := make(map[Property]int)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, errors.New("capacity '" + pair + "' is not property=accounts")
+		}
+		capacity, err := strconv.Atoi(pair[i+1:])
+		if err != nil || capacity < 0 {
+			return nil, errors.New("capacity '" + pair + "' is not a number of accounts")
+		}
+		capacities[Property(pair[:i])] = capacity
+	}
+	return capacities, nil
+}
+
+// SetCapacity caps how many accounts the connector follows, zero lifts the
+// cap.
+func (account_store *Store) SetCapacity(capacity int) {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	account_store.capacity = capacity
+	account_store.admit()
+}
+
+func (account_store *Store) Capacity() int {
+	account_store.rwlock.RLock()
+	defer account_store.rwlock.RUnlock()
+	return account_store.capacity
+}
+
+func (account_store *Store) SetPriority(account_id string, priority int) bool {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	account, present := account_store.account_entries[account_id]
+	if !present {
+		return false
+	}
+	if account.Priority() != priority {
+		account.SetPriority(priority)
+		account_store.readmit()
+	}
+	return true
+}
+
+// readmit has admission decided again on the next Admit, so a burst of
+// changes is sorted out once. The caller must hold the write lock.
+func (account_store *Store) readmit() {
+	if account_store.capacity > 0 {
+		account_store.admit_pending = true
+	}
+}
+
+// Admit decides again which accounts the connector follows if anything
+// changed since it last did, RestartMonitor calls it ahead of restarting
+// connectors. Accounts added under a capacity wait as PENDING until then.
+func (account_store *Store) Admit() {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	if account_store.admit_pending {
+		account_store.admit()
+	}
+}
+
+// admit decides which accounts the connector follows: the highest priority
+// first, then the most recently scanned. The rest is OVER_CAPACITY. Without a
+// capacity every account that is not dormant is followed. The caller must
+// hold the write lock.
+func (account_store *Store) admit() {
+	account_store.admit_pending = false
+	type candidate struct {
+		account_id string
+		account    *account_entry.Entry
+		priority   int
+		last_scan  int64
+	}
+	candidates := make([]candidate, 0, len(account_store.account_entries))
+	for account_id, account := range account_store.account_entries {
+		if account.State() == account_entry.DORMANT {
+			continue
+		}
+		candidates = append(candidates, candidate{account_id, account, account.Priority(), account.LastScanDt().Unix()})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.last_scan != b.last_scan {
+			return a.last_scan > b.last_scan
+		}
+		return a.account_id < b.account_id
+	})
+
+	admitted := make(map[string]bool, len(candidates))
+	reason := "capacity of " + strconv.Itoa(account_store.capacity) + " accounts reached"
+	for i, c := range candidates {
+		if account_store.capacity == 0 || i < account_store.capacity {
+			admitted[c.account_id] = true
+			c.account.Admit(true, "admitted")
+		} else {
+			c.account.Admit(false, reason)
+		}
+	}
+
+	// keep the order of the accounts that stay so an unchanged admission
+	// does not restart the connector
+	var new_slice []string
+	for _, account_id := range account_store.account_slice {
+		if admitted[account_id] {
+			new_slice = append(new_slice, account_id)
+			delete(admitted, account_id)
+		}
+	}
+	changed := len(new_slice) != len(account_store.account_slice) || len(admitted) > 0
+	for _, c := range candidates {
+		if admitted[c.account_id] {
+			new_slice = append(new_slice, c.account_id)
+		}
+	}
+	if changed {
+		account_store.account_slice = new_slice
+		account_store.restart = true
+	}
+	if account_store.follow_all {
+		for _, account_id := range account_store.account_slice {
+			account_store.followed(account_store.account_entries[account_id])
+		}
+	}
+}
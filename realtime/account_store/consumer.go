@@ -0,0 +1,133 @@
+package account_store
+
+import (
+	"sync"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/account_entry"
+)
+
+const DEFAULT_CONSUMER_TTL = 7 * 24 * time.Hour
+
+// Consumer is a named scanner with its own cursor on every account. Ids are
+// never reused so cursors of a removed consumer can never be inherited.
+type Consumer struct {
+	Name     string
+	Id       uint32
+	Created  time.Time
+	LastSeen time.Time
+	Ttl      time.Duration
+}
+
+type consumers struct {
+	by_name map[string]*Consumer
+	last_id uint32
+	rwlock  sync.RWMutex
+}
+
+func (account_store *Store) RegisterConsumer(name string, ttl time.Duration) Consumer {
+	c := &account_store.consumers
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	if ttl <= 0 {
+		ttl = DEFAULT_CONSUMER_TTL
+	}
+	if c.by_name == nil {
+		c.by_name = make(map[string]*Consumer)
+	}
+	consumer, present := c.by_name[name]
+	if present {
+		consumer.Ttl = ttl
+		return *consumer
+	}
+	c.last_id += 1
+	now := time.Now()
+	consumer = &Consumer{Name: name, Id: c.last_id, Created: now, LastSeen: now, Ttl: ttl}
+	c.by_name[name] = consumer
+	syslog.Infof("registered consumer %s with id %d", name, consumer.Id)
+	return *consumer
+}
+
+// Consumer looks up a registered consumer and marks it as seen.
+func (account_store *Store) Consumer(name string) (Consumer, bool) {
+	c := &account_store.consumers
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	consumer, present := c.by_name[name]
+	if !present {
+		return Consumer{}, false
+	}
+	consumer.LastSeen = time.Now()
+	return *consumer, true
+}
+
+func (account_store *Store) Consumers() []Consumer {
+	c := &account_store.consumers
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	list := make([]Consumer, 0, len(c.by_name))
+	for _, consumer := range c.by_name {
+		list = append(list, *consumer)
+	}
+	return list
+}
+
+func (account_store *Store) RemoveConsumer(name string) bool {
+	c := &account_store.consumers
+	c.rwlock.Lock()
+	consumer, present := c.by_name[name]
+	if present {
+		delete(c.by_name, name)
+	}
+	c.rwlock.Unlock()
+
+	if !present {
+		return false
+	}
+	account_store.dropCursors(consumer.Id)
+	syslog.Infof("removed consumer %s with id %d", name, consumer.Id)
+	return true
+}
+
+// ExpireConsumers removes the consumers that have not scanned for longer than
+// their ttl and returns their names.
+func (account_store *Store) ExpireConsumers(now time.Time) []string {
+	c := &account_store.consumers
+	var expired []*Consumer
+
+	c.rwlock.Lock()
+	for name, consumer := range c.by_name {
+		if now.Sub(consumer.LastSeen) > consumer.Ttl {
+			expired = append(expired, consumer)
+			delete(c.by_name, name)
+		}
+	}
+	c.rwlock.Unlock()
+
+	var names []string
+	for _, consumer := range expired {
+		account_store.dropCursors(consumer.Id)
+		syslog.Noticef("expired consumer %s with id %d, last seen %s", consumer.Name, consumer.Id, consumer.LastSeen)
+		names = append(names, consumer.Name)
+	}
+	return names
+}
+
+func (account_store *Store) dropCursors(consumer uint32) {
+	var entries []*account_entry.Entry
+
+	account_store.rwlock.RLock()
+	for _, entry := range account_store.account_entries {
+		entries = append(entries, entry)
+	}
+	account_store.rwlock.RUnlock()
+
+	for _, entry := range entries {
+		entry.DropConsumer(consumer)
+	}
+}
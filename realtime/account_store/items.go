@@ -0,0 +1,57 @@
+package account_store
+
+import (
+	"time"
+
+	"realtime/account_entry"
+	"realtime/itembuffer"
+)
+
+// SetItemBuffer makes the store keep the raw content of updates, a nil
+// buffer turns it off.
+func (account_store *Store) SetItemBuffer(items *itembuffer.Buffer) {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+	account_store.items = items
+}
+
+func (account_store *Store) itemBuffer() *itembuffer.Buffer {
+	account_store.rwlock.RLock()
+	defer account_store.rwlock.RUnlock()
+	return account_store.items
+}
+
+func (account_store *Store) ItemsBuffered() bool {
+	return account_store.itemBuffer() != nil
+}
+
+// RecordItem records an update of kind for the account like
+// Entry.SetLastUpdateKind and buffers raw under the update's sequence. Raw is
+// not copied and must not change after.
+func (account_store *Store) RecordItem(account *account_entry.Entry, kind account_entry.UpdateKind, raw []byte) bool {
+	sequence, counted := account.RecordUpdate(kind)
+	items := account_store.itemBuffer()
+	if counted && items != nil && len(raw) > 0 {
+		key := itembuffer.Key(string(account_store.Property), account.AccountId())
+		items.Add(key, sequence, kind.Names(), raw, time.Now())
+	}
+	return counted
+}
+
+// Items returns the buffered items of the account received after since.
+func (account_store *Store) Items(account_id string, since uint64) []itembuffer.Item {
+	items := account_store.itemBuffer()
+	if items == nil {
+		return nil
+	}
+	return items.Items(itembuffer.Key(string(account_store.Property), account_id), since, time.Now())
+}
+
+// ClearItems drops the buffered items of the account a scan consumed.
+func (account_store *Store) ClearItems(account_id string, through uint64) {
+	items := account_store.itemBuffer()
+	if items == nil {
+		return
+	}
+	items.Clear(itembuffer.Key(string(account_store.Property), account_id), through)
+}
@@ -0,0 +1,172 @@
+package account_store
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_LABELS       = 16
+	MAX_LABEL_LENGTH = 64
+)
+
+// labelIndex maps a label key and value to the accounts carrying it.
+type labelIndex map[string]map[string]map[string]bool
+
+func (index labelIndex) add(key string, value string, account_id string) {
+	values, present := index[key]
+	if !present {
+		values = make(map[string]map[string]bool)
+		index[key] = values
+	}
+	accounts, present := values[value]
+	if !present {
+		accounts = make(map[string]bool)
+		values[value] = accounts
+	}
+	accounts[account_id] = true
+}
+
+func (index labelIndex) remove(key string, value string, account_id string) {
+	accounts := index[key][value]
+	delete(accounts, account_id)
+	if len(accounts) == 0 {
+		delete(index[key], value)
+	}
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// ParseLabel splits "key:value", an empty value removes the label.
+func ParseLabel(label string) (string, string, error) {
+	i := strings.Index(label, ":")
+	if i <= 0 {
+		return "", "", errors.New("label '" + label + "' is not key:value")
+	}
+	key, value := label[:i], label[i+1:]
+	if len(key) > MAX_LABEL_LENGTH || len(value) > MAX_LABEL_LENGTH {
+		return "", "", errors.New("label '" + label + "' is too long")
+	}
+	return key, value, nil
+}
+
+// SetLabels sets the given labels of the account and keeps the others.
+func (account_store *Store) SetLabels(account_id string, labels map[string]string) error {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	account, present := account_store.account_entries[account_id]
+	if !present {
+		return errors.New("account " + account_id + " is not stored")
+	}
+	current := account.Labels()
+	for key, value := range labels {
+		if value == "" {
+			delete(current, key)
+		} else {
+			current[key] = value
+		}
+	}
+	if len(current) > MAX_LABELS {
+		return errors.New("accounts carry at most " + strconv.Itoa(MAX_LABELS) + " labels")
+	}
+
+	if account_store.labels == nil {
+		account_store.labels = make(labelIndex)
+	}
+	for key, value := range labels {
+		previous := account.SetLabel(key, value)
+		if previous != "" {
+			account_store.labels.remove(key, previous, account_id)
+		}
+		if value != "" {
+			account_store.labels.add(key, value, account_id)
+		}
+	}
+	return nil
+}
+
+// AccountsByLabel lists the accounts labelled key:value, sorted.
+func (account_store *Store) AccountsByLabel(key string, value string) []string {
+	account_store.rwlock.RLock()
+	defer account_store.rwlock.RUnlock()
+
+	accounts := account_store.labels[key][value]
+	account_ids := make([]string, 0, len(accounts))
+	for account_id := range accounts {
+		account_ids = append(account_ids, account_id)
+	}
+	sort.Strings(account_ids)
+	return account_ids
+}
+
+// LabelValues counts the accounts per value of a label key.
+func (account_store *Store) LabelValues(key string) map[string]int {
+	account_store.rwlock.RLock()
+	defer account_store.rwlock.RUnlock()
+
+	counts := make(map[string]int, len(account_store.labels[key]))
+	for value, accounts := range account_store.labels[key] {
+		counts[value] = len(accounts)
+	}
+	return counts
+}
+
+// SetPriorityByLabel reprioritizes every account labelled key:value and
+// has admission re-evaluated once.
+func (account_store *Store) SetPriorityByLabel(key string, value string, priority int) int {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	accounts := account_store.labels[key][value]
+	for account_id := range accounts {
+		account_store.account_entries[account_id].SetPriority(priority)
+	}
+	if len(accounts) > 0 {
+		account_store.readmit()
+	}
+	return len(accounts)
+}
+
+// RemoveByLabel stops monitoring every account labelled key:value, the
+// account slice is rebuilt and admission re-evaluated once.
+func (account_store *Store) RemoveByLabel(key string, value string) []string {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	removed := make(map[string]bool, len(account_store.labels[key][value]))
+	for account_id := range account_store.labels[key][value] {
+		removed[account_id] = true
+	}
+	account_ids := make([]string, 0, len(removed))
+	for account_id := range removed {
+		account_store.forget(account_id, account_store.account_entries[account_id])
+		account_ids = append(account_ids, account_id)
+	}
+	if len(account_ids) == 0 {
+		return account_ids
+	}
+	sort.Strings(account_ids)
+
+	var new_slice []string
+	for _, account_id := range account_store.account_slice {
+		if !removed[account_id] {
+			new_slice = append(new_slice, account_id)
+		}
+	}
+	account_store.account_slice = new_slice
+	account_store.restart = true
+	account_store.readmit()
+	return account_ids
+}
+
+// unlabel drops a removed account from the label index. The caller must
+// hold the write lock.
+func (account_store *Store) unlabel(account_id string, labels map[string]string) {
+	for key, value := range labels {
+		account_store.labels.remove(key, value, account_id)
+	}
+}
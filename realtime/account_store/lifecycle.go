@@ -0,0 +1,189 @@
+package account_store
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/account_entry"
+)
+
+// ANY_PROPERTY keys the lifecycle policy of properties without their own.
+const ANY_PROPERTY Property = "*"
+
+// LifecyclePolicy ages out accounts no scanner asks about anymore. An account
+// not scanned for DormantAfter turns DORMANT, a dormant account not scanned
+// for RemoveAfter is removed. Zero durations turn a step off.
+type LifecyclePolicy struct {
+	DormantAfter time.Duration
+	RemoveAfter  time.Duration
+}
+
+type LifecycleReport struct {
+	DryRun  bool
+	Dormant []string
+	Removed []string
+}
+
+// ParseLifecyclePolicies parses "<property>=<dormant after>[/<remove after>]"
+// pairs separated by commas, e.g. "twitterstream=720h/2160h,*=168h".
+func ParseLifecyclePolicies(value string) (map[Property]LifecyclePolicy, error) {
+	policies := make(map[Property]LifecyclePolicy)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, errors.New("lifecycle policy '" + pair + "' is not property=duration")
+		}
+		var policy LifecyclePolicy
+		durations := strings.SplitN(pair[i+1:], "/", 2)
+		var err error
+		if policy.DormantAfter, err = time.ParseDuration(durations[0]); err != nil {
+			return nil, err
+		}
+		if len(durations) == 2 {
+			if policy.RemoveAfter, err = time.ParseDuration(durations[1]); err != nil {
+				return nil, err
+			}
+		}
+		if policy.DormantAfter < 0 || policy.RemoveAfter < 0 || (policy.RemoveAfter > 0 && policy.RemoveAfter < policy.DormantAfter) {
+			return nil, errors.New("lifecycle policy '" + pair + "' must not remove accounts before they are dormant")
+		}
+		policies[Property(pair[:i])] = policy
+	}
+	return policies, nil
+}
+
+func (account_store *Store) SetLifecyclePolicy(policy LifecyclePolicy) {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+	account_store.lifecycle = policy
+}
+
+func (account_store *Store) LifecyclePolicy() LifecyclePolicy {
+	account_store.rwlock.RLock()
+	defer account_store.rwlock.RUnlock()
+	return account_store.lifecycle
+}
+
+// Collect applies the lifecycle policy, with dry_run it only reports what it
+// would do.
+func (account_store *Store) Collect(now time.Time, dry_run bool) LifecycleReport {
+	report := LifecycleReport{DryRun: dry_run, Dormant: make([]string, 0), Removed: make([]string, 0)}
+	policy := account_store.LifecyclePolicy()
+	if policy.DormantAfter == 0 {
+		return report
+	}
+
+	for account_id, account := range account_store.accountEntries() {
+		idle := now.Sub(account.LastScanDt())
+		if account.State() == account_entry.DORMANT {
+			if policy.RemoveAfter > 0 && idle > policy.RemoveAfter {
+				report.Removed = append(report.Removed, account_id)
+			}
+		} else if idle > policy.DormantAfter {
+			report.Dormant = append(report.Dormant, account_id)
+		}
+	}
+	if dry_run {
+		return report
+	}
+
+	// an account scanned or woken since the report was made is kept, so the
+	// accounts are checked again under the lock
+	account_store.rwlock.Lock()
+	dormant, removed := make([]string, 0, len(report.Dormant)), make([]string, 0, len(report.Removed))
+	for _, account_id := range report.Dormant {
+		account, present := account_store.account_entries[account_id]
+		if present && account.SleepIdle(now.Add(-policy.DormantAfter)) {
+			dormant = append(dormant, account_id)
+		}
+	}
+	for _, account_id := range report.Removed {
+		account, present := account_store.account_entries[account_id]
+		if present && account.State() == account_entry.DORMANT && now.Sub(account.LastScanDt()) > policy.RemoveAfter {
+			account_store.forget(account_id, account)
+			removed = append(removed, account_id)
+		}
+	}
+	report.Dormant, report.Removed = dormant, removed
+	if len(dormant) > 0 || len(removed) > 0 {
+		// dormant and removed accounts leave the slice in one rebuild
+		new_slice := make([]string, 0, len(account_store.account_slice))
+		for _, account_id := range account_store.account_slice {
+			if account, present := account_store.account_entries[account_id]; present && account.State() != account_entry.DORMANT {
+				new_slice = append(new_slice, account_id)
+			}
+		}
+		account_store.account_slice = new_slice
+		account_store.restart = true
+		account_store.readmit()
+	}
+	account_store.rwlock.Unlock()
+
+	if len(report.Dormant) > 0 || len(report.Removed) > 0 {
+		syslog.Noticef("%s lifecycle: %d accounts dormant, %d removed", account_store.Property, len(report.Dormant), len(report.Removed))
+	}
+	return report
+}
+
+// Sleep makes an account DORMANT and stops following it.
+func (account_store *Store) Sleep(account_id string) bool {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	account, present := account_store.account_entries[account_id]
+	if !present || account.State() == account_entry.DORMANT {
+		return false
+	}
+	account.Sleep()
+
+	var new_slice []string
+	for _, str := range account_store.account_slice {
+		if str == account_id {
+			continue
+		}
+		new_slice = append(new_slice, str)
+	}
+	account_store.account_slice = new_slice
+	account_store.restart = true
+	account_store.readmit()
+	return true
+}
+
+// Wake follows a DORMANT account again.
+func (account_store *Store) Wake(account_id string) bool {
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	account, present := account_store.account_entries[account_id]
+	if !present || account.State() != account_entry.DORMANT {
+		return false
+	}
+	account.Wake()
+	if account_store.capacity > 0 {
+		account_store.readmit()
+		return true
+	}
+	account_store.account_slice = append(account_store.account_slice, account_id)
+	account_store.restart = true
+	account_store.followed(account)
+	return true
+}
+
+// accountEntries copies the entries so they can be walked without the lock.
+func (account_store *Store) accountEntries() map[string]*account_entry.Entry {
+	account_store.rwlock.RLock()
+	defer account_store.rwlock.RUnlock()
+
+	entries := make(map[string]*account_entry.Entry, len(account_store.account_entries))
+	for account_id, account := range account_store.account_entries {
+		entries[account_id] = account
+	}
+	return entries
+}
@@ -0,0 +1,421 @@
+package account_store
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/account_entry"
+)
+
+const (
+	FORMAT_NDJSON     = "ndjson"
+	FORMAT_CSV        = "csv"
+	MAX_ACCOUNT_BYTES = 256
+)
+
+// Record is one exported account. Consumers maps consumer names to whether
+// they have content they did not scan yet.
+type Record struct {
+	Id           string
+	State        string
+	StateReason  string `json:",omitempty"`
+	StateChanged time.Time
+	LastScan     time.Time
+	LastUpdate   *time.Time        `json:",omitempty"`
+	Updated      bool              `json:",omitempty"`
+	Priority     int               `json:",omitempty"`
+	Kinds        []string          `json:",omitempty"`
+	Labels       map[string]string `json:",omitempty"`
+	Consumers    map[string]bool   `json:",omitempty"`
+	Cursor       string            `json:",omitempty"`
+	Line         int               `json:"-"`
+}
+
+type LineError struct {
+	Line  int
+	Id    string `json:",omitempty"`
+	Error string
+}
+
+type ImportReport struct {
+	Replace  bool
+	Imported int
+	Created  int
+	Removed  int
+	Errors   []LineError
+}
+
+var csvHeader = []string{"id", "state", "state_reason", "state_changed", "last_scan", "last_update", "updated", "priority", "kinds", "labels", "consumers", "cursor"}
+
+// ValidAccountId rejects ids no source uses: empty, overlong, or containing
+// slashes, whitespace or control characters.
+func ValidAccountId(account_id string) error {
+	if account_id == "" {
+		return errors.New("account id is empty")
+	}
+	if len(account_id) > MAX_ACCOUNT_BYTES {
+		return errors.New("account id is longer than " + strconv.Itoa(MAX_ACCOUNT_BYTES) + " bytes")
+	}
+	for _, r := range account_id {
+		if r == '/' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return errors.New("account id contains " + strconv.QuoteRune(r))
+		}
+	}
+	return nil
+}
+
+// Export returns every account sorted by id.
+func (account_store *Store) Export() []Record {
+	names := make(map[uint32]string)
+	for _, consumer := range account_store.Consumers() {
+		names[consumer.Id] = consumer.Name
+	}
+
+	entries := account_store.accountEntries()
+	records := make([]Record, 0, len(entries))
+	for _, entry := range entries {
+		snapshot := entry.Snapshot()
+		record := Record{
+			Id:           snapshot.AccountId,
+			State:        snapshot.State.String(),
+			StateReason:  snapshot.StateReason,
+			StateChanged: snapshot.StateDt,
+			LastScan:     snapshot.LastScanDt,
+			Updated:      snapshot.Updated,
+			Priority:     snapshot.Priority,
+			Kinds:        snapshot.KindPolicy.Names(),
+			Labels:       snapshot.Labels,
+			Cursor:       snapshot.Cursor,
+		}
+		for consumer, updated := range snapshot.Consumers {
+			if name, present := names[consumer]; present {
+				if record.Consumers == nil {
+					record.Consumers = make(map[string]bool)
+				}
+				record.Consumers[name] = updated
+			}
+		}
+		if !snapshot.LastUpdate.IsZero() {
+			record.LastUpdate = &snapshot.LastUpdate
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Id < records[j].Id })
+	return records
+}
+
+// Import stores the records, overwriting accounts already stored. With
+// replace accounts missing from the records are removed. Admission and the
+// connector restart happen once for the whole import.
+func (account_store *Store) Import(records []Record, replace bool) ImportReport {
+	report := ImportReport{Replace: replace, Errors: make([]LineError, 0)}
+
+	snapshots := make([]account_entry.Snapshot, 0, len(records))
+	var consumers []map[string]bool
+	for _, record := range records {
+		snapshot, err := snapshotOf(record)
+		if err != nil {
+			report.Errors = append(report.Errors, LineError{Line: record.Line, Id: record.Id, Error: err.Error()})
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+		consumers = append(consumers, record.Consumers)
+	}
+	// consumers are registered only for the records that are imported
+	for i, snapshot := range snapshots {
+		for name, updated := range consumers[i] {
+			consumer, present := account_store.Consumer(name)
+			if !present {
+				consumer = account_store.RegisterConsumer(name, 0)
+			}
+			snapshot.Consumers[consumer.Id] = updated
+		}
+	}
+
+	account_store.rwlock.Lock()
+	defer account_store.rwlock.Unlock()
+
+	imported := make(map[string]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		entry, present := account_store.account_entries[snapshot.AccountId]
+		if !present {
+			entry = account_store.newEntry(snapshot.AccountId)
+			report.Created += 1
+		}
+		entry.Restore(snapshot)
+		account_store.relabel(snapshot.AccountId, entry, snapshot.Labels)
+		imported[snapshot.AccountId] = true
+	}
+	report.Imported = len(imported)
+
+	if replace {
+		for account_id, entry := range account_store.account_entries {
+			if !imported[account_id] {
+				account_store.forget(account_id, entry)
+				report.Removed += 1
+			}
+		}
+	}
+	account_store.admit()
+	syslog.Noticef("%s import: %d accounts imported, %d created, %d removed, %d errors", account_store.Property, report.Imported, report.Created, report.Removed, len(report.Errors))
+	return report
+}
+
+// snapshotOf validates a record, the consumers it names are left to Import.
+func snapshotOf(record Record) (account_entry.Snapshot, error) {
+	if err := ValidAccountId(record.Id); err != nil {
+		return account_entry.Snapshot{}, err
+	}
+	kinds, valid := account_entry.ParseKinds(strings.Join(record.Kinds, ","))
+	if !valid {
+		return account_entry.Snapshot{}, errors.New("unknown kind in " + strings.Join(record.Kinds, ","))
+	}
+	if len(record.Labels) > MAX_LABELS {
+		return account_entry.Snapshot{}, errors.New("accounts carry at most " + strconv.Itoa(MAX_LABELS) + " labels")
+	}
+	for key, value := range record.Labels {
+		if _, _, err := ParseLabel(key + ":" + value); err != nil || value == "" {
+			return account_entry.Snapshot{}, errors.New("label '" + key + ":" + value + "' is invalid")
+		}
+	}
+	snapshot := account_entry.Snapshot{
+		AccountId:   record.Id,
+		StateReason: record.StateReason,
+		LastScanDt:  record.LastScan,
+		Updated:     record.Updated,
+		Priority:    record.Priority,
+		KindPolicy:  kinds,
+		Labels:      record.Labels,
+		Cursor:      record.Cursor,
+		Consumers:   make(map[uint32]bool, len(record.Consumers)),
+	}
+	if record.State == account_entry.DORMANT.String() {
+		snapshot.State = account_entry.DORMANT
+	}
+	if record.LastUpdate != nil {
+		snapshot.LastUpdate = *record.LastUpdate
+	}
+	if snapshot.LastScanDt.IsZero() {
+		snapshot.LastScanDt = time.Now()
+	}
+	for name := range record.Consumers {
+		if name == "" {
+			return account_entry.Snapshot{}, errors.New("consumer name is empty")
+		}
+	}
+	return snapshot, nil
+}
+
+// relabel replaces the labels of an entry. The caller must hold the write
+// lock.
+func (account_store *Store) relabel(account_id string, entry *account_entry.Entry, labels map[string]string) {
+	if account_store.labels == nil {
+		account_store.labels = make(labelIndex)
+	}
+	for key, value := range entry.Labels() {
+		if _, keep := labels[key]; !keep {
+			entry.SetLabel(key, "")
+			account_store.labels.remove(key, value, account_id)
+		}
+	}
+	for key, value := range labels {
+		if previous := entry.SetLabel(key, value); previous != "" {
+			account_store.labels.remove(key, previous, account_id)
+		}
+		account_store.labels.add(key, value, account_id)
+	}
+}
+
+func WriteRecords(w io.Writer, format string, records []Record) error {
+	switch format {
+	case FORMAT_NDJSON:
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FORMAT_CSV:
+		writer := csv.NewWriter(w)
+		writer.Write(csvHeader)
+		for _, record := range records {
+			writer.Write(csvRow(record))
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return errors.New("unknown format " + format)
+}
+
+// ReadRecords parses an export, lines that cannot be parsed are reported and
+// skipped.
+func ReadRecords(r io.Reader, format string) ([]Record, []LineError, error) {
+	var records []Record
+	var line_errors []LineError
+
+	switch format {
+	case FORMAT_NDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		line := 0
+		for scanner.Scan() {
+			line += 1
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var record Record
+			if err := json.Unmarshal([]byte(text), &record); err != nil {
+				line_errors = append(line_errors, LineError{Line: line, Error: err.Error()})
+				continue
+			}
+			record.Line = line
+			records = append(records, record)
+		}
+		return records, line_errors, scanner.Err()
+	case FORMAT_CSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err != nil {
+			return nil, nil, err
+		}
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[strings.TrimSpace(name)] = i
+		}
+		if _, present := columns["id"]; !present {
+			return nil, nil, errors.New("csv header has no id column")
+		}
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			// a quoted field may span lines, records are numbered by the
+			// line they start on
+			if err != nil {
+				line := 0
+				if parse_err, ok := err.(*csv.ParseError); ok {
+					line = parse_err.StartLine
+				}
+				line_errors = append(line_errors, LineError{Line: line, Error: err.Error()})
+				continue
+			}
+			line, _ := reader.FieldPos(0)
+			record, err := csvRecord(columns, row)
+			record.Line = line
+			if err != nil {
+				line_errors = append(line_errors, LineError{Line: line, Id: record.Id, Error: err.Error()})
+				continue
+			}
+			records = append(records, record)
+		}
+		return records, line_errors, nil
+	}
+	return nil, nil, errors.New("unknown format " + format)
+}
+
+func csvRow(record Record) []string {
+	var labels, consumers []string
+	for key, value := range record.Labels {
+		labels = append(labels, key+":"+value)
+	}
+	for name, updated := range record.Consumers {
+		consumers = append(consumers, name+":"+strconv.FormatBool(updated))
+	}
+	sort.Strings(labels)
+	sort.Strings(consumers)
+	last_update := ""
+	if record.LastUpdate != nil {
+		last_update = record.LastUpdate.Format(time.RFC3339)
+	}
+	return []string{
+		record.Id,
+		record.State,
+		record.StateReason,
+		record.StateChanged.Format(time.RFC3339),
+		record.LastScan.Format(time.RFC3339),
+		last_update,
+		strconv.FormatBool(record.Updated),
+		strconv.Itoa(record.Priority),
+		strings.Join(record.Kinds, ";"),
+		strings.Join(labels, ";"),
+		strings.Join(consumers, ";"),
+		record.Cursor,
+	}
+}
+
+func csvRecord(columns map[string]int, row []string) (Record, error) {
+	field := func(name string) string {
+		i, present := columns[name]
+		if !present || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	var record Record
+	var err error
+	record.Id = field("id")
+	record.State = field("state")
+	record.StateReason = field("state_reason")
+	record.Cursor = field("cursor")
+	var last_update time.Time
+	for name, t := range map[string]*time.Time{"state_changed": &record.StateChanged, "last_scan": &record.LastScan, "last_update": &last_update} {
+		if value := field(name); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				return record, errors.New(name + " is not an RFC 3339 time")
+			}
+		}
+	}
+	if !last_update.IsZero() {
+		record.LastUpdate = &last_update
+	}
+	if value := field("updated"); value != "" {
+		if record.Updated, err = strconv.ParseBool(value); err != nil {
+			return record, errors.New("updated is not a boolean")
+		}
+	}
+	if value := field("priority"); value != "" {
+		if record.Priority, err = strconv.Atoi(value); err != nil {
+			return record, errors.New("priority is not a number")
+		}
+	}
+	if value := field("kinds"); value != "" {
+		record.Kinds = strings.Split(value, ";")
+	}
+	if value := field("labels"); value != "" {
+		record.Labels = make(map[string]string)
+		for _, label := range strings.Split(value, ";") {
+			key, label_value, err := ParseLabel(label)
+			if err != nil {
+				return record, err
+			}
+			record.Labels[key] = label_value
+		}
+	}
+	if value := field("consumers"); value != "" {
+		record.Consumers = make(map[string]bool)
+		for _, consumer := range strings.Split(value, ";") {
+			i := strings.LastIndex(consumer, ":")
+			if i <= 0 {
+				return record, errors.New("consumer '" + consumer + "' is not name:updated")
+			}
+			if record.Consumers[consumer[:i]], err = strconv.ParseBool(consumer[i+1:]); err != nil {
+				return record, errors.New("consumer '" + consumer + "' is not name:updated")
+			}
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,144 @@
+package account_store
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// updateIndex queues the accounts whose default scanner has not consumed
+// their new content, oldest update first, so scanners can be handed work
+// without walking every account. Leased accounts wait apart, soonest expiry
+// first, and go back to their place in the queue once their lease expires.
+type updateIndex struct {
+	queue      *updateHeap
+	leased     *updateHeap
+	by_id      map[string]*queuedUpdate
+	next_order uint64
+	mutex      sync.Mutex
+}
+
+type queuedUpdate struct {
+	account_id  string
+	sequence    uint64
+	order       uint64
+	lease_until time.Time
+	leased      bool
+	index       int
+}
+
+// updateHeap is a container/heap of queued updates, index keeps the position
+// of each so Scanned removes it without a search.
+type updateHeap struct {
+	updates []*queuedUpdate
+	less    func(a *queuedUpdate, b *queuedUpdate) bool
+}
+
+func (h *updateHeap) Len() int           { return len(h.updates) }
+func (h *updateHeap) Less(i, j int) bool { return h.less(h.updates[i], h.updates[j]) }
+func (h *updateHeap) Swap(i, j int) {
+	h.updates[i], h.updates[j] = h.updates[j], h.updates[i]
+	h.updates[i].index = i
+	h.updates[j].index = j
+}
+func (h *updateHeap) Push(x interface{}) {
+	queued := x.(*queuedUpdate)
+	queued.index = len(h.updates)
+	h.updates = append(h.updates, queued)
+}
+func (h *updateHeap) Pop() interface{} {
+	last := len(h.updates) - 1
+	queued := h.updates[last]
+	h.updates[last] = nil
+	h.updates = h.updates[:last]
+	return queued
+}
+
+// Lease is an account handed to a scanner, it returns to the queue at
+// Expires unless the scanner scans the account first.
+type Lease struct {
+	AccountId string
+	Sequence  uint64
+	Expires   time.Time
+}
+
+func newUpdateIndex() *updateIndex {
+	index := new(updateIndex)
+	index.queue = &updateHeap{less: func(a *queuedUpdate, b *queuedUpdate) bool { return a.order < b.order }}
+	index.leased = &updateHeap{less: func(a *queuedUpdate, b *queuedUpdate) bool { return a.lease_until.Before(b.lease_until) }}
+	index.by_id = make(map[string]*queuedUpdate)
+	return index
+}
+
+func (index *updateIndex) Updated(account_id string, sequence uint64) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	if _, present := index.by_id[account_id]; present {
+		return
+	}
+	index.next_order += 1
+	queued := &queuedUpdate{account_id: account_id, sequence: sequence, order: index.next_order}
+	index.by_id[account_id] = queued
+	heap.Push(index.queue, queued)
+}
+
+func (index *updateIndex) Scanned(account_id string) {
+	index.remove(account_id)
+}
+
+func (index *updateIndex) remove(account_id string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	queued, present := index.by_id[account_id]
+	if !present {
+		return
+	}
+	if queued.leased {
+		heap.Remove(index.leased, queued.index)
+	} else {
+		heap.Remove(index.queue, queued.index)
+	}
+	delete(index.by_id, account_id)
+}
+
+// lease hands out up to limit queued accounts that are not leased already.
+// Accounts keep their place in the queue while leased.
+func (index *updateIndex) lease(limit int, visibility time.Duration, now time.Time) []Lease {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	for index.leased.Len() > 0 && !now.Before(index.leased.updates[0].lease_until) {
+		queued := heap.Pop(index.leased).(*queuedUpdate)
+		queued.leased = false
+		heap.Push(index.queue, queued)
+	}
+	var leases []Lease
+	for index.queue.Len() > 0 && len(leases) < limit {
+		queued := heap.Pop(index.queue).(*queuedUpdate)
+		queued.lease_until = now.Add(visibility)
+		queued.leased = true
+		heap.Push(index.leased, queued)
+		leases = append(leases, Lease{AccountId: queued.account_id, Sequence: queued.sequence, Expires: queued.lease_until})
+	}
+	return leases
+}
+
+func (index *updateIndex) len() int {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	return len(index.by_id)
+}
+
+// LeaseUpdated leases up to limit accounts with unscanned new content to the
+// caller for the visibility timeout, oldest update first.
+func (account_store *Store) LeaseUpdated(limit int, visibility time.Duration) []Lease {
+	return account_store.updates.lease(limit, visibility, time.Now())
+}
+
+// UpdatedCount is the number of accounts with unscanned new content.
+func (account_store *Store) UpdatedCount() int {
+	return account_store.updates.len()
+}
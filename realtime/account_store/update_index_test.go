@@ -0,0 +1,58 @@
+package account_store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUpdateIndexLease(t *testing.T) {
+	const visibility = 30 * time.Second
+	start := time.Unix(1500000000, 0)
+	index := newUpdateIndex()
+	for i, account_id := range []string{"a", "b", "c", "d"} {
+		index.Updated(account_id, uint64(i+1))
+	}
+	// an account updated again keeps its place
+	index.Updated("a", 9)
+
+	// steps run in order, scanned are scanned before leasing at start plus
+	// after
+	steps := []struct {
+		after   time.Duration
+		scanned []string
+		limit   int
+		leased  []string
+		queued  int
+	}{
+		{0, nil, 2, []string{"a", "b"}, 4},
+		{time.Second, nil, 1, []string{"c"}, 4},
+		// a scanned lease does not come back
+		{2 * time.Second, []string{"b"}, 10, []string{"d"}, 3},
+		{visibility - time.Second, nil, 10, nil, 3},
+		// expired leases are handed out again in update order
+		{visibility, nil, 10, []string{"a"}, 3},
+		{visibility + time.Second, nil, 10, []string{"c"}, 3},
+		{visibility + 2*time.Second, []string{"a", "c", "d"}, 10, nil, 0},
+		{10 * visibility, nil, 10, nil, 0},
+	}
+	for i, step := range steps {
+		for _, account_id := range step.scanned {
+			index.Scanned(account_id)
+		}
+		now := start.Add(step.after)
+		var leased []string
+		for _, lease := range index.lease(step.limit, visibility, now) {
+			if !lease.Expires.Equal(now.Add(visibility)) {
+				t.Errorf("step %d: lease of %s expires %v, want %v", i, lease.AccountId, lease.Expires, now.Add(visibility))
+			}
+			leased = append(leased, lease.AccountId)
+		}
+		if !reflect.DeepEqual(leased, step.leased) {
+			t.Errorf("step %d: lease() = %v, want %v", i, leased, step.leased)
+		}
+		if queued := index.len(); queued != step.queued {
+			t.Errorf("step %d: len() = %d, want %d", i, queued, step.queued)
+		}
+	}
+}
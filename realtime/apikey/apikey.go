@@ -0,0 +1,348 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+)
+
+const (
+	SCAN_READ        = "scan:read"
+	SCAN_WRITE       = "scan:write"
+	ACCOUNTS_DELETE  = "accounts:delete"
+	MANAGE_READ      = "manage:read"
+	MANAGE_WRITE     = "manage:write"
+	MANAGE_LIFECYCLE = "manage:lifecycle"
+	KEYS_ADMIN       = "keys:admin"
+	// CREDENTIALS_ROTATE lets a scanner replace the credential in use.
+	CREDENTIALS_ROTATE = "credentials:rotate"
+	// ALL grants every scope.
+	ALL = "*"
+
+	KEY_PREFIX = "rtk_"
+	// USAGE_SAVE_INTERVAL is how often FlushEvery is run with.
+	USAGE_SAVE_INTERVAL = 1 * time.Minute
+)
+
+var Scopes = []string{SCAN_READ, SCAN_WRITE, ACCOUNTS_DELETE, MANAGE_READ, MANAGE_WRITE, MANAGE_LIFECYCLE, KEYS_ADMIN, CREDENTIALS_ROTATE, ALL}
+
+var (
+	ErrKeyInvalid   = errors.New("api key is invalid")
+	ErrKeyRevoked   = errors.New("api key is revoked")
+	ErrScopeUnknown = errors.New("unknown scope")
+	ErrNoScopes     = errors.New("an api key needs at least one scope")
+	ErrSubjectTaken = errors.New("another api key is bound to the certificate subject")
+)
+
+// Key is an api key as kept in the store, the secret itself is only handed
+// out once by Create and kept as its SHA-256 hash. A key bound to a Subject
+// is also presented by a verified client certificate of that common name.
+type Key struct {
+	Id       string
+	Name     string
+	Scopes   []string
+	Subject  string `json:",omitempty"`
+	Hash     string `json:",omitempty"`
+	Created  time.Time
+	LastUsed *time.Time `json:",omitempty"`
+	Uses     int64
+	Denied   int64
+	Revoked  *time.Time `json:",omitempty"`
+}
+
+// Allows tells whether the key grants scope.
+func (k *Key) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ALL {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps the api keys in a JSON file readable by its owner only. Keys
+// are written through on every change, usage counters only by Flush so
+// authenticating never waits for the file.
+type Store struct {
+	path   string
+	rwlock sync.RWMutex
+	keys   map[string]*Key
+	dirty  bool
+}
+
+// Open reads the keys at path, a missing file is an empty store created on
+// the first Create.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, keys: make(map[string]*Key)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, errors.New(path + " holds no api keys")
+	}
+	for _, key := range keys {
+		s.keys[key.Id] = key
+	}
+	return s, nil
+}
+
+// ValidScopes checks that every scope is known.
+func ValidScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrNoScopes
+	}
+	for _, scope := range scopes {
+		known := false
+		for _, s := range Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			return errors.New(ErrScopeUnknown.Error() + " " + scope)
+		}
+	}
+	return nil
+}
+
+// Create adds a key, bound to a certificate subject when not empty, and
+// returns it with the token its holder sends. The token cannot be recovered
+// later.
+func (s *Store) Create(name string, scopes []string, subject string) (Key, string, error) {
+	if err := ValidScopes(scopes); err != nil {
+		return Key{}, "", err
+	}
+	id, err := random(6)
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := random(32)
+	if err != nil {
+		return Key{}, "", err
+	}
+	key := &Key{Id: hex.EncodeToString(id), Name: name, Scopes: scopes, Subject: subject, Hash: hash(secret), Created: time.Now()}
+
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	if subject != "" && s.bySubject(subject) != nil {
+		return Key{}, "", ErrSubjectTaken
+	}
+	s.keys[key.Id] = key
+	if err := s.save(); err != nil {
+		delete(s.keys, key.Id)
+		return Key{}, "", err
+	}
+	syslog.Noticef("api key %s (%s) created with scopes %s", key.Id, name, strings.Join(scopes, ","))
+	return key.public(), KEY_PREFIX + key.Id + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// List returns the keys oldest first, without their hashes.
+func (s *Store) List() []Key {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key.public())
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys
+}
+
+// Revoke keeps the key listed but refuses it from now on, it returns false
+// for an unknown or already revoked key.
+func (s *Store) Revoke(id string) (bool, error) {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	key, present := s.keys[id]
+	if !present || key.Revoked != nil {
+		return false, nil
+	}
+	now := time.Now()
+	key.Revoked = &now
+	syslog.Noticef("api key %s (%s) revoked", key.Id, key.Name)
+	return true, s.save()
+}
+
+// Authenticate returns the key of a token and counts the use.
+func (s *Store) Authenticate(token string) (Key, error) {
+	if !strings.HasPrefix(token, KEY_PREFIX) {
+		return Key{}, ErrKeyInvalid
+	}
+	parts := strings.SplitN(token[len(KEY_PREFIX):], "_", 2)
+	if len(parts) != 2 {
+		return Key{}, ErrKeyInvalid
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Key{}, ErrKeyInvalid
+	}
+
+	// a wrong token only takes the read lock
+	s.rwlock.RLock()
+	key, present := s.keys[parts[0]]
+	valid := present && subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(secret))) == 1
+	s.rwlock.RUnlock()
+	if !valid {
+		return Key{}, ErrKeyInvalid
+	}
+
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	return s.use(key)
+}
+
+// AuthenticateSubject returns the key bound to the common name of a verified
+// client certificate and counts the use.
+func (s *Store) AuthenticateSubject(subject string) (Key, error) {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	key := s.bySubject(subject)
+	if key == nil {
+		return Key{}, ErrKeyInvalid
+	}
+	return s.use(key)
+}
+
+// bySubject is called with the lock held, revoked keys give up their
+// subject.
+func (s *Store) bySubject(subject string) *Key {
+	for _, key := range s.keys {
+		if key.Subject == subject && key.Revoked == nil {
+			return key
+		}
+	}
+	return nil
+}
+
+// use is called with the lock held.
+func (s *Store) use(key *Key) (Key, error) {
+	if key.Revoked != nil {
+		return Key{}, ErrKeyRevoked
+	}
+	now := time.Now()
+	key.LastUsed = &now
+	key.Uses += 1
+	s.dirty = true
+	return key.public(), nil
+}
+
+// Deny counts a request the key lacked the scope for.
+func (s *Store) Deny(id string) {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	if key, present := s.keys[id]; present {
+		key.Denied += 1
+		s.dirty = true
+	}
+}
+
+// Flush writes usage counters not saved yet.
+func (s *Store) Flush() error {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// FlushEvery writes the usage counters in the background, main runs it with
+// USAGE_SAVE_INTERVAL.
+func (s *Store) FlushEvery(interval time.Duration) {
+	flushTimer := time.Tick(interval)
+	for {
+		select {
+		case <-flushTimer:
+			if err := s.Flush(); err != nil {
+				syslog.Errf("unable to save api key usage: %s", err)
+			}
+		}
+	}
+}
+
+// save is called with the lock held, the file is replaced whole so a crash
+// leaves either the old or the new keys.
+func (s *Store) save() error {
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func (key *Key) public() Key {
+	copied := *key
+	copied.Hash = ""
+	copied.Scopes = append([]string(nil), key.Scopes...)
+	return copied
+}
+
+func random(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func hash(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the key a request authenticated
+// with.
+func NewContext(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key of an authenticated request.
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(Key)
+	return key, ok
+}
@@ -0,0 +1,294 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+)
+
+const (
+	OUTCOME_OK     = "ok"
+	OUTCOME_FAILED = "failed"
+	OUTCOME_DENIED = "denied"
+
+	ACTOR_SYSTEM = "system"
+
+	DEFAULT_MAX_BYTES = 64 << 20
+	DEFAULT_KEEP      = 5
+	// RECENT_ENTRIES are kept in memory, all a log without a file answers
+	// queries from.
+	RECENT_ENTRIES = 1000
+	MAX_QUERY      = 10000
+)
+
+// Entry is one action, Before and After hold the state of the target the
+// action changed when there is one to tell.
+type Entry struct {
+	Id      int64
+	Time    time.Time
+	Actor   string
+	Action  string
+	Target  string
+	Before  interface{} `json:",omitempty"`
+	After   interface{} `json:",omitempty"`
+	Outcome string
+	Detail  string `json:",omitempty"`
+}
+
+// Filter selects entries, zero fields match everything. Actor and Target
+// match by prefix so "twitterstream" covers every account of the property.
+type Filter struct {
+	Since  time.Time
+	Until  time.Time
+	Actor  string
+	Action string
+	Target string
+	Limit  int
+}
+
+func (f Filter) match(e *Entry) bool {
+	return (f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until)) &&
+		strings.HasPrefix(e.Actor, f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		strings.HasPrefix(e.Target, f.Target)
+}
+
+// Log appends entries to a file that is rotated once it reaches max_bytes,
+// keeping the keep most recent rotated files as <path>.1 (newest) to
+// <path>.<keep>. Entries are only ever appended. A nil Log records nothing.
+type Log struct {
+	path      string
+	max_bytes int64
+	keep      int
+	file      *os.File
+	size      int64
+	next_id   int64
+	recent    []Entry
+	lock      sync.Mutex
+}
+
+// Open appends to the log at path, an empty path keeps the recent entries in
+// memory only.
+func Open(path string, max_bytes int64, keep int) (*Log, error) {
+	if max_bytes <= 0 {
+		max_bytes = DEFAULT_MAX_BYTES
+	}
+	if keep <= 0 {
+		keep = DEFAULT_KEEP
+	}
+	l := &Log{path: path, max_bytes: max_bytes, keep: keep}
+	if path == "" {
+		return l, nil
+	}
+	// ids continue from the last entry written, which is in the newest
+	// rotated file when the log was just rotated
+	for _, p := range []string{path, l.rotated(1)} {
+		file, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		var last int64
+		err = scan(file, func(e *Entry) { last = e.Id })
+		file.Close()
+		if err == nil && last > 0 {
+			l.next_id = last
+			break
+		}
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Record stamps the entry with an id and the time and appends it.
+func (l *Log) Record(e Entry) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.next_id += 1
+	e.Id = l.next_id
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.recent = append(l.recent, e)
+	if len(l.recent) > RECENT_ENTRIES {
+		l.recent = l.recent[len(l.recent)-RECENT_ENTRIES:]
+	}
+	if l.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		syslog.Errf("unable to encode audit entry %d: %s", e.Id, err)
+		return
+	}
+	line = append(line, '\n')
+	if l.size+int64(len(line)) > l.max_bytes {
+		l.rotate()
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		syslog.Errf("unable to write audit entry %d: %s", e.Id, err)
+	}
+}
+
+// rotate is called with the lock held.
+func (l *Log) rotate() {
+	l.file.Close()
+	os.Remove(l.rotated(l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(l.rotated(i), l.rotated(i+1))
+	}
+	os.Rename(l.path, l.rotated(1))
+	if err := l.open(); err != nil {
+		syslog.Errf("unable to reopen audit log %s: %s", l.path, err)
+		l.file = nil
+	}
+}
+
+func (l *Log) rotated(i int) string {
+	return l.path + "." + strconv.Itoa(i)
+}
+
+// Query returns the matching entries oldest first, the most recent ones when
+// there are more than the limit.
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	if l == nil {
+		return []Entry{}, nil
+	}
+	if f.Limit <= 0 || f.Limit > MAX_QUERY {
+		f.Limit = MAX_QUERY
+	}
+	matched := &tail{filter: f}
+
+	l.lock.Lock()
+	if l.file == nil {
+		for i := range l.recent {
+			matched.add(&l.recent[i])
+		}
+		l.lock.Unlock()
+		return matched.result(), nil
+	}
+	// the files are opened under the lock so a rotation cannot move them
+	// between reads, and read after Record may go on. The current file is
+	// read up to what was written so far.
+	var files []*os.File
+	var readers []io.Reader
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for i := l.keep; i >= 0; i-- {
+		path := l.path
+		if i > 0 {
+			path = l.rotated(i)
+		}
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			l.lock.Unlock()
+			return nil, err
+		}
+		files = append(files, file)
+		if i > 0 {
+			readers = append(readers, file)
+		} else {
+			readers = append(readers, io.LimitReader(file, l.size))
+		}
+	}
+	l.lock.Unlock()
+
+	for _, r := range readers {
+		if err := scan(r, matched.add); err != nil {
+			return nil, err
+		}
+	}
+	return matched.result(), nil
+}
+
+// tail keeps the most recent entries that match the filter, up to its limit.
+type tail struct {
+	filter  Filter
+	entries []Entry
+}
+
+func (t *tail) add(e *Entry) {
+	if !t.filter.match(e) {
+		return
+	}
+	t.entries = append(t.entries, *e)
+	// dropped in batches so the entries are not copied on every add
+	if len(t.entries) >= 2*t.filter.Limit {
+		t.entries = append([]Entry(nil), t.entries[len(t.entries)-t.filter.Limit:]...)
+	}
+}
+
+func (t *tail) result() []Entry {
+	if len(t.entries) > t.filter.Limit {
+		return t.entries[len(t.entries)-t.filter.Limit:]
+	}
+	if t.entries == nil {
+		return []Entry{}
+	}
+	return t.entries
+}
+
+// scan hands each entry in r to each, a line cut short by a crash is
+// skipped.
+func scan(r io.Reader, each func(*Entry)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			each(&e)
+		}
+	}
+	return scanner.Err()
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
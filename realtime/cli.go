@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"realtime/apikey"
+	"realtime/manager"
+)
+
+const API_KEY_ENV = "REALTIME_API_KEY"
+
+// runCommand runs the export, import and keys subcommands against the
+// management api of a running instance and returns the exit code.
+func runCommand(command string, args []string) int {
+	if command == "keys" {
+		return runKeysCommand(args)
+	}
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	server := flags.String("server", "http://localhost:8080", "Base url of the realtime instance.")
+	api_key := flags.String("api_key", os.Getenv(API_KEY_ENV), "Api key sent to the instance, defaults to $"+API_KEY_ENV+".")
+	property := flags.String("property", "", "Property whose accounts are transferred, e.g. twitterstream. Required.")
+	format := flags.String("format", "ndjson", "ndjson or csv.")
+	file := flags.String("file", "-", "File to write the export to or read the import from, - for stdout or stdin.")
+	mode := flags.String("mode", "merge", "Import mode: merge keeps accounts missing from the file, replace removes them.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s export|import -property <property> [options]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *property == "" {
+		flags.Usage()
+		return 2
+	}
+
+	query := url.Values{"format": {*format}}
+	base := strings.TrimRight(*server, "/") + "/_manage/accounts/" + url.PathEscape(*property)
+
+	var resp *http.Response
+	var err error
+	var out io.Writer = os.Stdout
+	switch command {
+	case "export":
+		if *file != "-" {
+			f, err := os.Create(*file)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			out = f
+		}
+		resp, err = apiRequest("GET", base+"/_export?"+query.Encode(), *api_key, nil)
+	case "import":
+		var in io.Reader = os.Stdin
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			in = f
+		}
+		query.Set("mode", *mode)
+		resp, err = apiRequest("POST", base+"/_import?"+query.Encode(), *api_key, in)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stderr, resp.Body)
+		return 1
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func apiRequest(method string, url string, api_key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if api_key != "" {
+		req.Header.Set(manager.API_KEY_HEADER, api_key)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// runKeysCommand creates, lists and revokes api keys, through the management
+// api or, with -file, directly in the key file of a stopped instance which is
+// how the first key is made.
+func runKeysCommand(args []string) int {
+	flags := flag.NewFlagSet("keys", flag.ExitOnError)
+	server := flags.String("server", "http://localhost:8080", "Base url of the realtime instance.")
+	api_key := flags.String("api_key", os.Getenv(API_KEY_ENV), "Api key with the keys:admin scope, defaults to $"+API_KEY_ENV+".")
+	file := flags.String("file", "", "Key file to change directly instead of going through the instance.")
+	name := flags.String("name", "", "Name of the key to create.")
+	scopes := flags.String("scopes", "", "Comma separated scopes of the key to create: "+strings.Join(apikey.Scopes, ", "))
+	subject := flags.String("subject", "", "Common name of the client certificates that also present the key to create.")
+	id := flags.String("id", "", "Id of the key to revoke.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s keys create|list|revoke [options]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+	action := args[0]
+	flags.Parse(args[1:])
+
+	var keys []apikey.Key
+	switch action {
+	case "create":
+		scope_list := strings.Split(*scopes, ",")
+		if *scopes == "" {
+			flags.Usage()
+			return 2
+		}
+		var key apikey.Key
+		var token string
+		if *file != "" {
+			store, err := apikey.Open(*file)
+			if err == nil {
+				key, token, err = store.Create(*name, scope_list, *subject)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		} else {
+			body, _ := json.Marshal(map[string]interface{}{"Name": *name, "Scopes": scope_list, "Subject": *subject})
+			var created struct {
+				apikey.Key
+				Token string
+			}
+			if code := apiCall("POST", *server+manager.MANAGE_PREFIX+"/keys", *api_key, bytes.NewReader(body), &created); code != 0 {
+				return code
+			}
+			key, token = created.Key, created.Token
+		}
+		fmt.Fprintf(os.Stderr, "created key %s, the token is not shown again:\n", key.Id)
+		fmt.Println(token)
+		return 0
+	case "list":
+		if *file != "" {
+			store, err := apikey.Open(*file)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			keys = store.List()
+		} else if code := apiCall("GET", *server+manager.MANAGE_PREFIX+"/keys", *api_key, nil, &keys); code != 0 {
+			return code
+		}
+	case "revoke":
+		if *id == "" {
+			flags.Usage()
+			return 2
+		}
+		if *file != "" {
+			store, err := apikey.Open(*file)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			revoked, err := store.Revoke(*id)
+			if err != nil || !revoked {
+				fmt.Fprintln(os.Stderr, "no such key", *id, err)
+				return 1
+			}
+			return 0
+		}
+		return apiCall("DELETE", *server+manager.MANAGE_PREFIX+"/keys/"+url.PathEscape(*id), *api_key, nil, nil)
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tSUBJECT\tUSES\tDENIED\tLAST USED\tREVOKED")
+	for _, key := range keys {
+		last_used, revoked := "-", "-"
+		if key.LastUsed != nil {
+			last_used = key.LastUsed.Format(time.RFC3339)
+		}
+		if key.Revoked != nil {
+			revoked = key.Revoked.Format(time.RFC3339)
+		}
+		subject := key.Subject
+		if subject == "" {
+			subject = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", key.Id, key.Name, strings.Join(key.Scopes, ","), subject, key.Uses, key.Denied, last_used, revoked)
+	}
+	w.Flush()
+	return 0
+}
+
+// apiCall makes a management api request and decodes the response into v
+// when not nil, it returns the exit code.
+func apiCall(method string, url string, api_key string, body io.Reader, v interface{}) int {
+	resp, err := apiRequest(method, url, api_key, body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		io.Copy(os.Stderr, resp.Body)
+		return 1
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -2,8 +2,20 @@ package credential
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"sync"
+
+	"engines/github.com.garyburd.go-oauth/oauth2"
+)
+
+// AuthKind is how a credential signs requests.
+type AuthKind string
+
+const (
+	AUTH_OAUTH1 AuthKind = "oauth1"
+	AUTH_BEARER AuthKind = "bearer"
+	AUTH_OAUTH2 AuthKind = "oauth2"
 )
 
 type JsonCredential struct {
@@ -11,13 +23,29 @@ type JsonCredential struct {
 	AppSecret           string `json:"app_secret"`
 	ApiOauthToken       string `json:"api_oauth_token"`
 	ApiOauthTokenSecret string `json:"api_oauth_token_secret"`
+	BearerToken         string `json:"bearer_token,omitempty"`
+	// OAuth2 is used instead of the OAuth 1.0a fields when set.
+	OAuth2 *OAuth2Credential `json:"oauth2,omitempty"`
+	// Version is the secret generation, zero lets the credential count it.
+	Version int `json:"version,omitempty"`
 }
 type Credential struct {
 	app_id                 string
 	app_secret             string
 	api_oauth_token        string
 	api_oauth_token_secret string
+	bearer_token           string
+	oauth2_client          *OAuth2Credential
+	token_source           *oauth2.CachingTokenSource
+	refresh_token          string
+	version                int
+	source                 string
+	persist                func(*JsonCredential)
 	stale                  bool
+	optional               bool
+	kinds                  []AuthKind
+	rotation               rotation
+	health                 health
 	rwlock                 sync.RWMutex
 }
 
@@ -27,13 +55,70 @@ func NewCredential() *Credential {
 	return c
 }
 
+// NewOptionalCredential is used by properties whose source needs no
+// credential, PUT requests for those properties are not required to carry one.
+func NewOptionalCredential() *Credential {
+	c := NewCredential()
+	c.optional = true
+	return c
+}
+
+func (c *Credential) Optional() bool {
+	return c.optional
+}
+
 func (credential *JsonCredential) Valid() bool {
+	if credential.BearerToken != "" {
+		return true
+	}
+	if credential.OAuth2 != nil {
+		return credential.OAuth2.Valid()
+	}
 	if credential.AppId == "" || credential.AppSecret == "" || credential.ApiOauthToken == "" || credential.ApiOauthTokenSecret == "" {
 		return false
 	}
 	return true
 }
 
+// Kind tells how the credential signs requests, a bearer token is used
+// before an OAuth 2.0 client and that before the OAuth 1.0a keys.
+func (credential *JsonCredential) Kind() AuthKind {
+	if credential.BearerToken != "" {
+		return AUTH_BEARER
+	}
+	if credential.OAuth2 != nil {
+		return AUTH_OAUTH2
+	}
+	return AUTH_OAUTH1
+}
+
+// SetKinds is called by connectors that only sign requests some ways, without
+// it any valid credential is accepted.
+func (c *Credential) SetKinds(kinds ...AuthKind) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.kinds = kinds
+}
+
+// Accepts tells whether json_credential is complete and of a kind the
+// connector signs with.
+func (c *Credential) Accepts(json_credential *JsonCredential) bool {
+	if !json_credential.Valid() {
+		return false
+	}
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	if len(c.kinds) == 0 {
+		return true
+	}
+	for _, kind := range c.kinds {
+		if json_credential.Kind() == kind {
+			return true
+		}
+	}
+	return false
+}
+
 func CredentialFromJson(r io.ReadCloser) *JsonCredential {
 	json_credential := new(JsonCredential)
 	dec := json.NewDecoder(r)
@@ -50,7 +135,7 @@ func (c *Credential) Changed(new_credential *JsonCredential) bool {
 	c.rwlock.RLock()
 	defer c.rwlock.RUnlock()
 
-	if c.app_id == new_credential.AppId && c.app_secret == new_credential.AppSecret && c.api_oauth_token == new_credential.ApiOauthToken && c.api_oauth_token_secret == new_credential.ApiOauthTokenSecret {
+	if c.app_id == new_credential.AppId && c.app_secret == new_credential.AppSecret && c.api_oauth_token == new_credential.ApiOauthToken && c.api_oauth_token_secret == new_credential.ApiOauthTokenSecret && c.bearer_token == new_credential.BearerToken && sameOAuth2(c.oauth2_client, new_credential.OAuth2) {
 		return false
 	}
 	return true
@@ -62,9 +147,11 @@ func (c *Credential) Stale() bool {
 	return c.stale
 }
 
+// SetStale lets the next scanner replace the credential, a loaded credential
+// is kept.
 func (c *Credential) SetStale() {
 	c.rwlock.RLock()
-	if c.stale == true {
+	if c.stale == true || c.source != "" {
 		c.rwlock.RUnlock()
 		return
 	}
@@ -84,18 +171,81 @@ func (c *Credential) Update(new_credential *JsonCredential) {
 	if false && c.stale == false {
 		return
 	}
-	if false && !c.Changed(new_credential) {
+	changed := c.Changed(new_credential)
+	if false && !changed {
 		return
 	}
 	c.rwlock.Lock()
-	defer c.rwlock.Unlock()
+	c.update(new_credential, changed)
+	persist := c.persist
+	c.rwlock.Unlock()
+
+	if persist != nil && changed {
+		persist(c.Json())
+	}
+}
+
+// update is called with the lock held.
+func (c *Credential) update(new_credential *JsonCredential, changed bool) {
+	if changed {
+		c.health = health{verifier: c.health.verifier}
+		c.token_source = nil
+		c.refresh_token = ""
+	}
+	switch {
+	case new_credential.Version != 0:
+		c.version = new_credential.Version
+	case changed:
+		c.version++
+	}
 	c.app_id = new_credential.AppId
 	c.app_secret = new_credential.AppSecret
 	c.api_oauth_token = new_credential.ApiOauthToken
 	c.api_oauth_token_secret = new_credential.ApiOauthTokenSecret
+	c.bearer_token = new_credential.BearerToken
+	c.oauth2_client = copyOAuth2(new_credential.OAuth2)
 	c.stale = false
+}
 
-	return
+// Load sets a credential that came from a secret source rather than from a
+// scanner, source names it for management output.
+func (c *Credential) Load(new_credential *JsonCredential, source string) {
+	changed := c.Changed(new_credential)
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.update(new_credential, changed)
+	c.source = source
+}
+
+// Source is where a loaded credential came from, empty when scanners supply
+// it.
+func (c *Credential) Source() string {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	return c.source
+}
+
+// SetPersist has every change of the credential handed to persist, outside
+// of the credential's lock.
+func (c *Credential) SetPersist(persist func(*JsonCredential)) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.persist = persist
+}
+
+func (c *Credential) Version() int {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	return c.version
+}
+
+// String keeps the secrets out of anything that formats a credential.
+func (c *Credential) String() string {
+	return c.Json().String()
+}
+
+func (credential JsonCredential) String() string {
+	return fmt.Sprintf("credential %s v%d", credential.Id(), credential.Version)
 }
 
 func (c *Credential) AppId() string {
@@ -122,3 +272,9 @@ func (c *Credential) ApiOauthTokenSecret() string {
 
 	return c.api_oauth_token_secret
 }
+func (c *Credential) BearerToken() string {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	return c.bearer_token
+}
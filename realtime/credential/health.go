@@ -0,0 +1,121 @@
+package credential
+
+import (
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+)
+
+type HealthEnum string
+
+const (
+	HEALTH_UNKNOWN HealthEnum = "unknown"
+	HEALTH_VALID   HealthEnum = "valid"
+	// HEALTH_INVALID is a credential the source rejected, connectors stop
+	// reconnecting with it until it is replaced or found valid again.
+	HEALTH_INVALID HealthEnum = "invalid"
+	// HEALTH_UNREACHABLE is a credential the source could not be asked about.
+	HEALTH_UNREACHABLE HealthEnum = "unreachable"
+)
+
+// Verifier asks the source whether it accepts a credential. It returns an
+// InvalidError when the source rejected it, any other error means the source
+// could not tell.
+type Verifier func(*JsonCredential) error
+
+type InvalidError struct {
+	Reason string
+}
+
+func (err InvalidError) Error() string {
+	return "credential rejected: " + err.Reason
+}
+
+type health struct {
+	verifier Verifier
+	state    HealthEnum
+	reason   string
+	checked  time.Time
+}
+
+// SetVerifier is called by the connector that knows how to check the
+// credential with its source.
+func (c *Credential) SetVerifier(verifier Verifier) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.health.verifier = verifier
+}
+
+// Verify checks the credential with the source and records the result, it
+// returns HEALTH_UNKNOWN without a verifier.
+func (c *Credential) Verify() HealthEnum {
+	c.rwlock.RLock()
+	verifier := c.health.verifier
+	c.rwlock.RUnlock()
+	if verifier == nil {
+		return HEALTH_UNKNOWN
+	}
+
+	verified := c.Json()
+	err := verifier(verified)
+	// the result is about a credential that has since been replaced
+	if c.Changed(verified) {
+		return HEALTH_UNKNOWN
+	}
+	switch err := err.(type) {
+	case nil:
+		c.SetHealth(HEALTH_VALID, "")
+	case InvalidError:
+		c.SetHealth(HEALTH_INVALID, err.Reason)
+	default:
+		c.SetHealth(HEALTH_UNREACHABLE, err.Error())
+	}
+	health, _, _ := c.Health()
+	return health
+}
+
+// VerifyOnce verifies a credential that has not been checked since it was
+// set, connectors call it before opening their source.
+func (c *Credential) VerifyOnce() HealthEnum {
+	if health, _, _ := c.Health(); health != HEALTH_UNKNOWN {
+		return health
+	}
+	return c.Verify()
+}
+
+// SetHealth records what the source said about the credential.
+func (c *Credential) SetHealth(health HealthEnum, reason string) {
+	c.rwlock.Lock()
+	previous := c.health.state
+	c.health.state = health
+	c.health.reason = reason
+	c.health.checked = time.Now()
+	id := c.id()
+	c.rwlock.Unlock()
+
+	if health == HEALTH_INVALID && previous != HEALTH_INVALID {
+		syslog.Alertf("credential %s is invalid: %s", id, reason)
+	} else if previous == HEALTH_INVALID && health == HEALTH_VALID {
+		syslog.Noticef("credential %s is valid again", id)
+	}
+}
+
+// Reject marks the credential invalid, for connectors the source refused
+// outright.
+func (c *Credential) Reject(reason string) {
+	c.SetHealth(HEALTH_INVALID, reason)
+}
+
+func (c *Credential) Health() (HealthEnum, string, time.Time) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	if c.health.state == "" {
+		return HEALTH_UNKNOWN, "", time.Time{}
+	}
+	return c.health.state, c.health.reason, c.health.checked
+}
+
+func (c *Credential) Invalid() bool {
+	health, _, _ := c.Health()
+	return health == HEALTH_INVALID
+}
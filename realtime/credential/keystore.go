@@ -0,0 +1,153 @@
+package credential
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	KEYSTORE_MAGIC    = "rtks1"
+	MASTER_KEY_LENGTH = 32
+)
+
+var ErrKeystoreKey = errors.New("keystore cannot be decrypted with this master key")
+
+// Keystore is a local file of named credentials encrypted with AES-256-GCM
+// under a master key, credentials are named by property.
+type Keystore struct {
+	path        string
+	aead        cipher.AEAD
+	rwlock      sync.RWMutex
+	credentials map[string]*JsonCredential
+}
+
+// ParseMasterKey decodes a 32 byte master key given in base64 or hex.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != MASTER_KEY_LENGTH {
+		key, err = hex.DecodeString(encoded)
+	}
+	if err != nil || len(key) != MASTER_KEY_LENGTH {
+		return nil, errors.New("master key must be 32 bytes in base64 or hex")
+	}
+	return key, nil
+}
+
+// OpenKeystore reads the keystore at path, a missing file is an empty
+// keystore created on the first Put.
+func OpenKeystore(path string, master_key []byte) (*Keystore, error) {
+	block, err := aes.NewCipher(master_key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keystore{path: path, aead: aead, credentials: make(map[string]*JsonCredential)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := len(KEYSTORE_MAGIC) + aead.NonceSize()
+	if len(data) < header || string(data[:len(KEYSTORE_MAGIC)]) != KEYSTORE_MAGIC {
+		return nil, errors.New(path + " is not a keystore")
+	}
+	plain, err := aead.Open(nil, data[len(KEYSTORE_MAGIC):header], data[header:], []byte(KEYSTORE_MAGIC))
+	if err != nil {
+		return nil, ErrKeystoreKey
+	}
+	if err := json.Unmarshal(plain, &k.credentials); err != nil {
+		return nil, errors.New(path + " holds no credentials")
+	}
+	return k, nil
+}
+
+func (k *Keystore) Get(name string) (*JsonCredential, bool) {
+	k.rwlock.RLock()
+	defer k.rwlock.RUnlock()
+	json_credential, present := k.credentials[name]
+	if !present {
+		return nil, false
+	}
+	copied := *json_credential
+	return &copied, true
+}
+
+// Put stores the credential under name and rewrites the file.
+func (k *Keystore) Put(name string, json_credential *JsonCredential) error {
+	k.rwlock.Lock()
+	defer k.rwlock.Unlock()
+	copied := *json_credential
+	k.credentials[name] = &copied
+	return k.save()
+}
+
+// Delete drops the credential named name and rewrites the file.
+func (k *Keystore) Delete(name string) error {
+	k.rwlock.Lock()
+	defer k.rwlock.Unlock()
+	if _, present := k.credentials[name]; !present {
+		return nil
+	}
+	delete(k.credentials, name)
+	return k.save()
+}
+
+func (k *Keystore) Names() []string {
+	k.rwlock.RLock()
+	defer k.rwlock.RUnlock()
+	names := make([]string, 0, len(k.credentials))
+	for name := range k.credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// save is called with the lock held, the file is replaced whole so a crash
+// leaves either the old or the new keystore.
+func (k *Keystore) save() error {
+	plain, err := json.Marshal(k.credentials)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := append([]byte(KEYSTORE_MAGIC), nonce...)
+	data = k.aead.Seal(data, nonce, plain, []byte(KEYSTORE_MAGIC))
+
+	f, err := ioutil.TempFile(filepath.Dir(k.path), filepath.Base(k.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), k.path)
+}
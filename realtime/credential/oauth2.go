@@ -0,0 +1,154 @@
+package credential
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"engines/github.com.garyburd.go-oauth/oauth2"
+)
+
+const TOKEN_TIMEOUT = 30 * time.Second
+
+var ErrNoAccessToken = errors.New("credential has no bearer token or OAuth 2.0 client")
+
+// OAuth2Credential is an OAuth 2.0 client of the source. Tokens are granted
+// by refresh token when it has one and for the client itself otherwise.
+type OAuth2Credential struct {
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	TokenUrl     string `json:"token_url"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// Scopes are space separated.
+	Scopes string `json:"scopes,omitempty"`
+}
+
+func (o *OAuth2Credential) Valid() bool {
+	return o.ClientId != "" && o.TokenUrl != "" && (o.ClientSecret != "" || o.RefreshToken != "")
+}
+
+// sameOAuth2 compares clients, not the refresh tokens granted to them which
+// the server may rotate.
+func sameOAuth2(a *OAuth2Credential, b *OAuth2Credential) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ClientId == b.ClientId && a.ClientSecret == b.ClientSecret && a.TokenUrl == b.TokenUrl && a.Scopes == b.Scopes
+}
+
+func copyOAuth2(o *OAuth2Credential) *OAuth2Credential {
+	if o == nil {
+		return nil
+	}
+	copied := *o
+	return &copied
+}
+
+// OAuth2 returns true for a credential that is an OAuth 2.0 client rather
+// than OAuth 1.0a material.
+func (c *Credential) OAuth2() bool {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	return c.oauth2_client != nil
+}
+
+// AccessToken returns the bearer token to make requests with, the static one
+// or one granted to the OAuth 2.0 client. Granted tokens are cached and
+// replaced ahead of their expiry, a grant the server refuses rejects the
+// credential and is returned as an InvalidError.
+func (c *Credential) AccessToken() (string, error) {
+	c.rwlock.Lock()
+	if c.bearer_token != "" {
+		defer c.rwlock.Unlock()
+		return c.bearer_token, nil
+	}
+	if c.oauth2_client == nil {
+		c.rwlock.Unlock()
+		return "", ErrNoAccessToken
+	}
+	if c.token_source == nil {
+		c.token_source = c.newTokenSource()
+	}
+	source := c.token_source
+	c.rwlock.Unlock()
+
+	token, err := source.Token()
+	if reason, refused := refusedGrant(err); refused {
+		c.Reject(reason)
+		return "", InvalidError{Reason: reason}
+	}
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// AccessToken asks for a token for a client that is not the credential in
+// use, e.g. one being verified. Nothing is cached and a grant the server
+// refuses is returned as an InvalidError.
+func (o *OAuth2Credential) AccessToken() (string, error) {
+	token, err := o.tokenSource().Token()
+	if reason, refused := refusedGrant(err); refused {
+		return "", InvalidError{Reason: reason}
+	}
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func refusedGrant(err error) (string, bool) {
+	if oauth_err, ok := err.(*oauth2.Error); ok {
+		switch oauth_err.Code {
+		case "invalid_client", "invalid_grant", "unauthorized_client":
+			return oauth_err.Error(), true
+		}
+	}
+	return "", false
+}
+
+func (o *OAuth2Credential) tokenSource() *oauth2.CachingTokenSource {
+	config := &oauth2.Config{
+		ClientID:     o.ClientId,
+		ClientSecret: o.ClientSecret,
+		TokenURL:     o.TokenUrl,
+		Scopes:       strings.Fields(o.Scopes),
+	}
+	http_client := &http.Client{Timeout: TOKEN_TIMEOUT}
+
+	if o.RefreshToken != "" {
+		return config.TokenSource(http_client, &oauth2.Token{RefreshToken: o.RefreshToken})
+	}
+	return config.ClientCredentialsSource(http_client)
+}
+
+// newTokenSource is called with the lock held.
+func (c *Credential) newTokenSource() *oauth2.CachingTokenSource {
+	source := c.oauth2_client.tokenSource()
+	// servers may rotate the refresh token, the next one has to be kept. It
+	// is kept apart from the client, which still matches the credential
+	// scanners send.
+	source.OnToken = func(token *oauth2.Token) {
+		c.rwlock.Lock()
+		rotated := c.token_source == source && token.RefreshToken != "" && token.RefreshToken != c.refreshToken()
+		if rotated {
+			c.refresh_token = token.RefreshToken
+		}
+		persist := c.persist
+		c.rwlock.Unlock()
+
+		if rotated && persist != nil {
+			persist(c.Json())
+		}
+	}
+	return source
+}
+
+// refreshToken is called with the lock held.
+func (c *Credential) refreshToken() string {
+	if c.refresh_token != "" {
+		return c.refresh_token
+	}
+	return c.oauth2_client.RefreshToken
+}
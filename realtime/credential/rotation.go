@@ -0,0 +1,214 @@
+package credential
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+)
+
+const (
+	DEFAULT_FALLBACK_WINDOW = 1 * time.Hour
+	MAX_ROTATION_EVENTS     = 50
+
+	ROTATION_ROTATED  = "rotated"
+	ROTATION_FAILED   = "failed"
+	ROTATION_REVERTED = "reverted"
+)
+
+// FallbackWindow is how long a rotated out credential stays usable as a
+// fallback when a rotation does not name a window of its own.
+var FallbackWindow = DEFAULT_FALLBACK_WINDOW
+
+// RotationHook, when set, is called with every rotation event of every
+// credential.
+var RotationHook func(e RotationEvent)
+
+var (
+	ErrRotationInvalid   = errors.New("credential is invalid")
+	ErrRotationUnchanged = errors.New("credential is unchanged")
+	ErrRotationBusy      = errors.New("credential is already rotating")
+)
+
+// Rotator brings a running connector over to the next credential. It returns
+// once the connector receives with it, or with the error that kept it from
+// doing so in which case the connector keeps the current credential.
+type Rotator func(next *JsonCredential) error
+
+type RotationEvent struct {
+	Time   time.Time
+	Event  string
+	From   string
+	To     string `json:",omitempty"`
+	Detail string `json:",omitempty"`
+}
+
+type rotation struct {
+	lock           sync.Mutex
+	rotator        Rotator
+	fallback       *JsonCredential
+	fallback_until time.Time
+	events         []RotationEvent
+}
+
+// SetRotator is called by connectors that can switch credential while
+// running, without one a rotation takes effect on the next request made with
+// the credential.
+func (c *Credential) SetRotator(rotator Rotator) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.rotation.rotator = rotator
+}
+
+// Json returns a copy of the current values.
+func (c *Credential) Json() *JsonCredential {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	return c.json()
+}
+
+// json is called with the lock held.
+func (c *Credential) json() *JsonCredential {
+	oauth2_client := copyOAuth2(c.oauth2_client)
+	if oauth2_client != nil {
+		oauth2_client.RefreshToken = c.refreshToken()
+	}
+	return &JsonCredential{
+		AppId:               c.app_id,
+		AppSecret:           c.app_secret,
+		ApiOauthToken:       c.api_oauth_token,
+		ApiOauthTokenSecret: c.api_oauth_token_secret,
+		BearerToken:         c.bearer_token,
+		OAuth2:              oauth2_client,
+		Version:             c.version,
+	}
+}
+
+// Rotate validates the next credential, has the connector cut over to it and
+// only then retires the current one, which is kept as a fallback for window
+// (FallbackWindow when zero). A stale credential is not in use and is simply
+// updated.
+func (c *Credential) Rotate(next *JsonCredential, window time.Duration) error {
+	if window <= 0 {
+		window = FallbackWindow
+	}
+	if !c.rotation.lock.TryLock() {
+		return ErrRotationBusy
+	}
+	defer c.rotation.lock.Unlock()
+
+	current := c.Json()
+	if !c.Accepts(next) {
+		c.record(ROTATION_FAILED, current, next, ErrRotationInvalid.Error())
+		return ErrRotationInvalid
+	}
+	if !c.Changed(next) {
+		return ErrRotationUnchanged
+	}
+	if c.Stale() {
+		c.Update(next)
+		c.record(ROTATION_ROTATED, current, next, "credential was not in use")
+		return nil
+	}
+
+	c.rwlock.RLock()
+	rotator := c.rotation.rotator
+	c.rwlock.RUnlock()
+	if rotator != nil {
+		if err := rotator(next); err != nil {
+			c.record(ROTATION_FAILED, current, next, err.Error())
+			return err
+		}
+	}
+
+	c.rwlock.Lock()
+	c.rotation.fallback = current
+	c.rotation.fallback_until = time.Now().Add(window)
+	c.rwlock.Unlock()
+	c.Update(next)
+	c.record(ROTATION_ROTATED, current, next, "")
+	return nil
+}
+
+// Revert goes back to the rotated out credential while its fallback window
+// lasts, connectors call it when the current credential stops working. It
+// returns false when there is no fallback left.
+func (c *Credential) Revert(detail string) bool {
+	c.rwlock.Lock()
+	fallback := c.rotation.fallback
+	if fallback == nil || time.Now().After(c.rotation.fallback_until) {
+		c.rotation.fallback = nil
+		c.rwlock.Unlock()
+		return false
+	}
+	c.rotation.fallback = nil
+	c.rwlock.Unlock()
+
+	current := c.Json()
+	c.Update(fallback)
+	c.record(ROTATION_REVERTED, current, fallback, detail)
+	return true
+}
+
+// FallbackUntil returns the end of the fallback window, zero without a
+// fallback.
+func (c *Credential) FallbackUntil() time.Time {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	if c.rotation.fallback == nil || time.Now().After(c.rotation.fallback_until) {
+		return time.Time{}
+	}
+	return c.rotation.fallback_until
+}
+
+// Rotations returns the most recent rotation events, oldest first.
+func (c *Credential) Rotations() []RotationEvent {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+	events := make([]RotationEvent, len(c.rotation.events))
+	copy(events, c.rotation.events)
+	return events
+}
+
+func (c *Credential) record(event string, from *JsonCredential, to *JsonCredential, detail string) {
+	e := RotationEvent{Time: time.Now(), Event: event, From: from.Id(), To: to.Id(), Detail: detail}
+	syslog.Noticef("credential %s: %s to %s %s", e.Event, e.From, e.To, e.Detail)
+	if RotationHook != nil {
+		RotationHook(e)
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	c.rotation.events = append(c.rotation.events, e)
+	if len(c.rotation.events) > MAX_ROTATION_EVENTS {
+		c.rotation.events = c.rotation.events[len(c.rotation.events)-MAX_ROTATION_EVENTS:]
+	}
+}
+
+// id is called with the lock held.
+func (c *Credential) id() string {
+	return c.json().Id()
+}
+
+// Id identifies a credential in logs and management output without giving
+// its secrets away, they are reduced to a fingerprint.
+func (credential *JsonCredential) Id() string {
+	if credential.BearerToken != "" {
+		return "bearer#" + fingerprint(credential.BearerToken)
+	}
+	if credential.OAuth2 != nil {
+		return "oauth2:" + credential.OAuth2.ClientId + "#" + fingerprint(credential.OAuth2.ClientSecret+"&"+credential.OAuth2.RefreshToken)
+	}
+	if credential.AppId == "" {
+		return ""
+	}
+	return credential.AppId + "#" + fingerprint(credential.AppSecret+"&"+credential.ApiOauthToken+"&"+credential.ApiOauthTokenSecret)
+}
+
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
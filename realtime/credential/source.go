@@ -0,0 +1,92 @@
+package credential
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	SOURCE_ENV      = "env"
+	SOURCE_FILE     = "file"
+	SOURCE_KEYSTORE = "keystore"
+)
+
+// EnvPrefix is the prefix of the environment variables holding the
+// credential of property, e.g. REALTIME_TWITTERSTREAM.
+func EnvPrefix(property string) string {
+	prefix := []byte("REALTIME_" + strings.ToUpper(property))
+	for i, b := range prefix {
+		if !(b >= 'A' && b <= 'Z' || b >= '0' && b <= '9') {
+			prefix[i] = '_'
+		}
+	}
+	return string(prefix)
+}
+
+// FromEnv reads a credential from <prefix>_APP_ID, _APP_SECRET,
+// _API_OAUTH_TOKEN, _API_OAUTH_TOKEN_SECRET, _BEARER_TOKEN, the OAuth 2.0
+// client in _OAUTH2_CLIENT_ID, _OAUTH2_CLIENT_SECRET, _OAUTH2_TOKEN_URL,
+// _OAUTH2_REFRESH_TOKEN and _OAUTH2_SCOPES, and _VERSION. It returns nil when
+// none of them is set.
+func FromEnv(prefix string) (*JsonCredential, error) {
+	json_credential := &JsonCredential{
+		AppId:               os.Getenv(prefix + "_APP_ID"),
+		AppSecret:           os.Getenv(prefix + "_APP_SECRET"),
+		ApiOauthToken:       os.Getenv(prefix + "_API_OAUTH_TOKEN"),
+		ApiOauthTokenSecret: os.Getenv(prefix + "_API_OAUTH_TOKEN_SECRET"),
+		BearerToken:         os.Getenv(prefix + "_BEARER_TOKEN"),
+	}
+	if client_id := os.Getenv(prefix + "_OAUTH2_CLIENT_ID"); client_id != "" {
+		json_credential.OAuth2 = &OAuth2Credential{
+			ClientId:     client_id,
+			ClientSecret: os.Getenv(prefix + "_OAUTH2_CLIENT_SECRET"),
+			TokenUrl:     os.Getenv(prefix + "_OAUTH2_TOKEN_URL"),
+			RefreshToken: os.Getenv(prefix + "_OAUTH2_REFRESH_TOKEN"),
+			Scopes:       os.Getenv(prefix + "_OAUTH2_SCOPES"),
+		}
+	}
+	if *json_credential == (JsonCredential{}) {
+		return nil, nil
+	}
+	if version := os.Getenv(prefix + "_VERSION"); version != "" {
+		var err error
+		json_credential.Version, err = strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("%s_VERSION is not a number", prefix)
+		}
+	}
+	if !json_credential.Valid() {
+		return nil, fmt.Errorf("%s_* variables do not make a complete credential", prefix)
+	}
+	return json_credential, nil
+}
+
+// FromFile reads a JSON credential from a file only its owner may read.
+func FromFile(path string) (*JsonCredential, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by group or others, it must be mode 0600 or stricter", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	json_credential := new(JsonCredential)
+	if err := json.NewDecoder(f).Decode(json_credential); err != nil {
+		// the decoder error may quote the secrets
+		return nil, errors.New(path + " does not hold a JSON credential")
+	}
+	if !json_credential.Valid() {
+		return nil, errors.New(path + " does not hold a complete credential")
+	}
+	return json_credential, nil
+}
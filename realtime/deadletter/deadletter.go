@@ -0,0 +1,204 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+)
+
+type Reason string
+
+const (
+	UNPARSABLE Reason = "unparsable"
+	UNROUTABLE Reason = "unroutable"
+	UNEXPECTED Reason = "unexpected"
+)
+
+const (
+	SPILL_FILE      = "deadletter.ndjson"
+	SPILL_MAX_BYTES = 64 << 20
+)
+
+type Letter struct {
+	Id       int64
+	Property string
+	Reason   Reason
+	Detail   string
+	Received time.Time
+	Raw      []byte
+}
+
+// Store keeps the most recent letters in memory. Letters pushed out of
+// memory are appended to a spill file when a spill directory is configured,
+// the spill file is rotated once so disk usage is bounded as well.
+type Store struct {
+	capacity    int
+	letters     []*Letter
+	next_id     int64
+	counts      map[string]int64
+	spill_dir   string
+	spill       *os.File
+	spill_bytes int64
+	rwlock      sync.RWMutex
+}
+
+func New(capacity int, spill_dir string) *Store {
+	store := new(Store)
+	store.capacity = capacity
+	store.counts = make(map[string]int64)
+	store.spill_dir = spill_dir
+	return store
+}
+
+func counterKey(property string, reason Reason) string {
+	return property + "/" + string(reason)
+}
+
+// Add quarantines a copy of raw.
+func (store *Store) Add(property string, reason Reason, detail string, raw []byte) *Letter {
+	store.rwlock.Lock()
+	defer store.rwlock.Unlock()
+
+	store.next_id += 1
+	letter := &Letter{
+		Id:       store.next_id,
+		Property: property,
+		Reason:   reason,
+		Detail:   detail,
+		Received: time.Now(),
+		Raw:      append([]byte(nil), raw...),
+	}
+	store.counts[counterKey(property, reason)] += 1
+
+	store.letters = append(store.letters, letter)
+	for len(store.letters) > store.capacity {
+		store.spillLetter(store.letters[0])
+		store.letters[0] = nil
+		store.letters = store.letters[1:]
+	}
+	syslog.Infof("quarantined letter %d for %s: %s %s", letter.Id, property, reason, detail)
+	return letter
+}
+
+func (store *Store) spillLetter(letter *Letter) {
+	if store.spill_dir == "" {
+		return
+	}
+	if store.spill == nil || store.spill_bytes > SPILL_MAX_BYTES {
+		if err := store.rotateSpill(); err != nil {
+			syslog.Errf("unable to open dead letter spill file: %s", err)
+			return
+		}
+	}
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	n, err := store.spill.Write(line)
+	store.spill_bytes += int64(n)
+	if err != nil {
+		syslog.Errf("unable to spill dead letter %d: %s", letter.Id, err)
+	}
+}
+
+func (store *Store) rotateSpill() error {
+	path := filepath.Join(store.spill_dir, SPILL_FILE)
+	if store.spill != nil {
+		store.spill.Close()
+		store.spill = nil
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	store.spill = f
+	store.spill_bytes = info.Size()
+	return nil
+}
+
+// Letters returns the letters held in memory, oldest first, optionally
+// filtered by property and reason.
+func (store *Store) Letters(property string, reason Reason) []*Letter {
+	store.rwlock.RLock()
+	defer store.rwlock.RUnlock()
+
+	var letters []*Letter
+	for _, letter := range store.letters {
+		if property != "" && letter.Property != property {
+			continue
+		}
+		if reason != "" && letter.Reason != reason {
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func (store *Store) Letter(id int64) (*Letter, bool) {
+	store.rwlock.RLock()
+	defer store.rwlock.RUnlock()
+
+	for _, letter := range store.letters {
+		if letter.Id == id {
+			return letter, true
+		}
+	}
+	return nil, false
+}
+
+// Counts returns the number of letters ever quarantined keyed by
+// "<property>/<reason>", including those no longer held in memory.
+func (store *Store) Counts() map[string]int64 {
+	store.rwlock.RLock()
+	defer store.rwlock.RUnlock()
+
+	counts := make(map[string]int64, len(store.counts))
+	for k, v := range store.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// Clear drops the letters held in memory, counters and the spill file are
+// kept.
+func (store *Store) Clear() {
+	store.rwlock.Lock()
+	defer store.rwlock.Unlock()
+
+	store.letters = nil
+}
+
+// Close spills the letters held in memory, so a restart loses none of them,
+// and closes the spill file.
+func (store *Store) Close() error {
+	store.rwlock.Lock()
+	defer store.rwlock.Unlock()
+
+	if store.spill_dir == "" {
+		return nil
+	}
+	for _, letter := range store.letters {
+		store.spillLetter(letter)
+	}
+	store.letters = nil
+	if store.spill == nil {
+		return nil
+	}
+	err := store.spill.Close()
+	store.spill = nil
+	return err
+}
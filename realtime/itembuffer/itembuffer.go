@@ -0,0 +1,235 @@
+package itembuffer
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type EvictReason string
+
+const (
+	EVICT_COUNT   EvictReason = "count"
+	EVICT_AGE     EvictReason = "age"
+	EVICT_MEMORY  EvictReason = "memory"
+	EVICT_CLEARED EvictReason = "cleared"
+	EVICT_REMOVED EvictReason = "removed"
+)
+
+const (
+	DEFAULT_MAX_ITEMS = 20
+	DEFAULT_MAX_AGE   = 1 * time.Hour
+)
+
+// Item is raw content received for an account. Sequence is the update
+// sequence it was recorded under in the account's store.
+type Item struct {
+	Sequence uint64
+	Kinds    []string `json:",omitempty"`
+	Received time.Time
+	Raw      json.RawMessage
+}
+
+type bufferedItem struct {
+	key  string
+	item Item
+}
+
+// body identifies a raw body by its memory, for the items sharing it to be
+// counted once.
+type body struct {
+	start  *byte
+	length int
+}
+
+func bodyOf(raw []byte) body {
+	return body{start: &raw[0], length: len(raw)}
+}
+
+type Stats struct {
+	MaxBytes int64
+	MaxItems int
+	MaxAge   string
+	// Bytes counts a body shared by several accounts once.
+	Bytes          int64
+	Items          int
+	Accounts       int
+	Added          int64
+	Evicted        map[EvictReason]int64
+	RejectedTooBig int64
+	RejectedJson   int64
+}
+
+// Buffer keeps the most recent items of every account, at most max_items per
+// account and none older than max_age. All accounts share max_bytes, once it
+// is reached the oldest items of any account are evicted first. A body added
+// for several accounts is counted once, until the last of its items goes.
+type Buffer struct {
+	max_items int
+	max_age   time.Duration
+	max_bytes int64
+	bytes     int64
+	order     *list.List
+	accounts  map[string][]*list.Element
+	bodies    map[body]int
+	added     int64
+	evicted   map[EvictReason]int64
+	too_big   int64
+	bad_json  int64
+	mutex     sync.Mutex
+}
+
+func New(max_bytes int64, max_items int, max_age time.Duration) *Buffer {
+	buffer := new(Buffer)
+	if max_items <= 0 {
+		max_items = DEFAULT_MAX_ITEMS
+	}
+	if max_age <= 0 {
+		max_age = DEFAULT_MAX_AGE
+	}
+	buffer.max_bytes = max_bytes
+	buffer.max_items = max_items
+	buffer.max_age = max_age
+	buffer.order = list.New()
+	buffer.accounts = make(map[string][]*list.Element)
+	buffer.bodies = make(map[body]int)
+	buffer.evicted = make(map[EvictReason]int64)
+	return buffer
+}
+
+func Key(property string, account_id string) string {
+	return property + "/" + account_id
+}
+
+// Add buffers raw for the account stored under key. Raw is kept as is, the
+// accounts it is added for share it, so the caller must not change it after.
+// Raw that is not valid JSON is refused as items are served as JSON.
+func (buffer *Buffer) Add(key string, sequence uint64, kinds []string, raw []byte, now time.Time) {
+	size := int64(len(raw))
+	valid := json.Valid(raw)
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	if !valid {
+		buffer.bad_json += 1
+		return
+	}
+	if size > buffer.max_bytes {
+		buffer.too_big += 1
+		return
+	}
+	buffer.expire(now)
+
+	item := Item{Sequence: sequence, Kinds: kinds, Received: now, Raw: json.RawMessage(raw)}
+	element := buffer.order.PushBack(&bufferedItem{key: key, item: item})
+	buffer.accounts[key] = append(buffer.accounts[key], element)
+	if buffer.bodies[bodyOf(raw)] == 0 {
+		buffer.bytes += size
+	}
+	buffer.bodies[bodyOf(raw)] += 1
+	buffer.added += 1
+
+	for len(buffer.accounts[key]) > buffer.max_items {
+		buffer.evict(buffer.accounts[key][0], EVICT_COUNT)
+	}
+	for buffer.bytes > buffer.max_bytes {
+		buffer.evict(buffer.order.Front(), EVICT_MEMORY)
+	}
+}
+
+// Items returns the items of the account received after sequence since,
+// oldest first.
+func (buffer *Buffer) Items(key string, since uint64, now time.Time) []Item {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	buffer.expire(now)
+	items := make([]Item, 0)
+	for _, element := range buffer.accounts[key] {
+		item := element.Value.(*bufferedItem).item
+		if item.Sequence > since {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Clear drops the items of the account up to and including sequence through.
+func (buffer *Buffer) Clear(key string, through uint64) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	for {
+		elements := buffer.accounts[key]
+		if len(elements) == 0 || elements[0].Value.(*bufferedItem).item.Sequence > through {
+			return
+		}
+		buffer.evict(elements[0], EVICT_CLEARED)
+	}
+}
+
+// Remove drops every item of an account that is no longer monitored.
+func (buffer *Buffer) Remove(key string) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	for len(buffer.accounts[key]) > 0 {
+		buffer.evict(buffer.accounts[key][0], EVICT_REMOVED)
+	}
+}
+
+func (buffer *Buffer) Stats() Stats {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	buffer.expire(time.Now())
+	evicted := make(map[EvictReason]int64, len(buffer.evicted))
+	for k, v := range buffer.evicted {
+		evicted[k] = v
+	}
+	return Stats{
+		MaxBytes:       buffer.max_bytes,
+		MaxItems:       buffer.max_items,
+		MaxAge:         buffer.max_age.String(),
+		Bytes:          buffer.bytes,
+		Items:          buffer.order.Len(),
+		Accounts:       len(buffer.accounts),
+		Added:          buffer.added,
+		Evicted:        evicted,
+		RejectedTooBig: buffer.too_big,
+		RejectedJson:   buffer.bad_json,
+	}
+}
+
+// expire evicts items older than max_age, the order list is oldest first so
+// it stops at the first young item. The caller must hold the lock.
+func (buffer *Buffer) expire(now time.Time) {
+	for element := buffer.order.Front(); element != nil; element = buffer.order.Front() {
+		if now.Sub(element.Value.(*bufferedItem).item.Received) <= buffer.max_age {
+			return
+		}
+		buffer.evict(element, EVICT_AGE)
+	}
+}
+
+// evict removes an element, which is always the oldest item of its account.
+// The caller must hold the lock.
+func (buffer *Buffer) evict(element *list.Element, reason EvictReason) {
+	buffered := element.Value.(*bufferedItem)
+	buffer.order.Remove(element)
+	shared := bodyOf(buffered.item.Raw)
+	if buffer.bodies[shared] -= 1; buffer.bodies[shared] == 0 {
+		delete(buffer.bodies, shared)
+		buffer.bytes -= int64(len(buffered.item.Raw))
+	}
+	buffer.evicted[reason] += 1
+
+	elements := buffer.accounts[buffered.key]
+	if len(elements) <= 1 {
+		delete(buffer.accounts, buffered.key)
+		return
+	}
+	elements[0] = nil
+	buffer.accounts[buffered.key] = elements[1:]
+}
@@ -0,0 +1,63 @@
+package jsonpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Collect returns the string and number values selected by path in the json
+// document. Path elements are separated by dots and an element ending in []
+// iterates an array, e.g. "events[].account.id" or "[].id_str".
+func Collect(body []byte, path string) ([]string, error) {
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var values []string
+	collect(doc, strings.Split(path, "."), &values)
+	return values, nil
+}
+
+func collect(v interface{}, path []string, values *[]string) {
+	if len(path) == 0 {
+		switch t := v.(type) {
+		case string:
+			*values = append(*values, t)
+		case json.Number:
+			*values = append(*values, t.String())
+		case []interface{}:
+			for _, e := range t {
+				collect(e, nil, values)
+			}
+		}
+		return
+	}
+
+	key := path[0]
+	iterate := strings.HasSuffix(key, "[]")
+	key = strings.TrimSuffix(key, "[]")
+	if key != "" {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		v, ok = m[key]
+		if !ok {
+			return
+		}
+	}
+	if iterate {
+		arr, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		for _, e := range arr {
+			collect(e, path[1:], values)
+		}
+		return
+	}
+	collect(v, path[1:], values)
+}
@@ -0,0 +1,190 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+)
+
+const (
+	CLIENT_AUTH_NONE     = ""
+	CLIENT_AUTH_OPTIONAL = "optional"
+	CLIENT_AUTH_REQUIRE  = "require"
+
+	DEFAULT_WATCH_INTERVAL = 10 * time.Second
+)
+
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the CA bundle client certificates are verified
+	// against, ClientAuth whether clients must present one.
+	ClientCAFile string
+	ClientAuth   string
+}
+
+// TLS serves the certificate and client CAs last read from their files. A
+// reload that fails keeps the previous ones.
+type TLS struct {
+	config   Config
+	rwlock   sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modified map[string]time.Time
+}
+
+func NewTLS(config Config) (*TLS, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("tls needs both a certificate and a key file")
+	}
+	switch config.ClientAuth {
+	case CLIENT_AUTH_NONE, CLIENT_AUTH_OPTIONAL, CLIENT_AUTH_REQUIRE:
+	default:
+		return nil, errors.New("client auth must be optional or require")
+	}
+	if config.ClientAuth != CLIENT_AUTH_NONE && config.ClientCAFile == "" {
+		return nil, errors.New("client auth needs a client CA bundle")
+	}
+	t := &TLS{config: config}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *TLS) files() []string {
+	files := []string{t.config.CertFile, t.config.KeyFile}
+	if t.config.ClientCAFile != "" {
+		files = append(files, t.config.ClientCAFile)
+	}
+	return files
+}
+
+// Reload reads the certificate, key and client CAs again.
+func (t *TLS) Reload() error {
+	modified := make(map[string]time.Time)
+	for _, file := range t.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modified[file] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(t.config.CertFile, t.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if t.config.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(t.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New(t.config.ClientCAFile + " holds no certificates")
+		}
+	}
+
+	t.rwlock.Lock()
+	defer t.rwlock.Unlock()
+	t.cert = &cert
+	t.pool = pool
+	t.modified = modified
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		syslog.Noticef("tls certificate %s loaded, valid until %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// changed tells whether a file was modified since it was last read.
+func (t *TLS) changed() bool {
+	t.rwlock.RLock()
+	defer t.rwlock.RUnlock()
+	for _, file := range t.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(t.modified[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads once any of the files changes, a certificate renewed in
+// place is picked up without a restart.
+func (t *TLS) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = DEFAULT_WATCH_INTERVAL
+	}
+	watchTimer := time.Tick(interval)
+	for {
+		select {
+		case <-watchTimer:
+			if !t.changed() {
+				continue
+			}
+			if err := t.Reload(); err != nil {
+				syslog.Errf("unable to reload tls files, keeping the previous ones: %s", err)
+			}
+		}
+	}
+}
+
+// Config is given to servers, every handshake gets the current certificate
+// and client CAs.
+func (t *TLS) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t.rwlock.RLock()
+			defer t.rwlock.RUnlock()
+			config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*t.cert}, ClientCAs: t.pool}
+			switch t.config.ClientAuth {
+			case CLIENT_AUTH_OPTIONAL:
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			case CLIENT_AUTH_REQUIRE:
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
+
+// Subject returns the common name of the verified client certificate of a
+// request.
+func Subject(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	return name, name != ""
+}
+
+// Listen binds addr right away, so a port in use fails startup, and serves
+// handler on it in the background, with TLS when t is not nil.
+func Listen(name string, addr string, handler http.Handler, t *TLS) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Addr: addr, Handler: handler}
+	if t != nil {
+		server.TLSConfig = t.Config()
+		ln = tls.NewListener(ln, server.TLSConfig)
+	}
+	syslog.Noticef("%s listening on %s, tls %t", name, ln.Addr(), t != nil)
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			syslog.Critf("%s listener on %s failed: %s", name, addr, err)
+		}
+	}()
+	return server, nil
+}
@@ -5,10 +5,12 @@ import (
 
 	"realtime/account_store"
 	"realtime/credential"
+	"realtime/deadletter"
 )
 
 type BaseConnector struct {
 	baseManager
+	dead_letters *deadletter.Store
 }
 
 func (b *BaseConnector) InitBaseConnector(name string, store *account_store.Store, credential *credential.Credential) {
@@ -19,3 +21,17 @@ func (b *BaseConnector) InitBaseConnector(name string, store *account_store.Stor
 func (b *BaseConnector) Type() ConnectorEnum {
 	return CONNECTOR
 }
+
+func (b *BaseConnector) SetDeadLetters(dead_letters *deadletter.Store) {
+	b.dead_letters = dead_letters
+}
+
+// Quarantine hands content the connector cannot process to the dead letter
+// store instead of dropping it.
+func (b *BaseConnector) Quarantine(reason deadletter.Reason, detail string, raw []byte) {
+	if b.dead_letters == nil {
+		b.Logger.Warningf("dropping %s content (%s): %s\n", reason, detail, raw)
+		return
+	}
+	b.dead_letters.Add(b.Name(), reason, detail, raw)
+}
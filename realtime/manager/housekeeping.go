@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/credential"
+)
+
+const HOUSEKEEPING_INTERVAL = 1 * time.Minute
+
+// Housekeeping periodically expires what the stores no longer need.
+func Housekeeping(managers []Manager) {
+	housekeepingTimer := time.Tick(HOUSEKEEPING_INTERVAL)
+	for {
+		select {
+		case now := <-housekeepingTimer:
+			for name, store := range Stores(managers) {
+				expired := store.ExpireConsumers(now)
+				if len(expired) > 0 {
+					syslog.Noticef("expired consumers %v of %s", expired, name)
+				}
+				report := store.Collect(now, false)
+				if len(report.Removed) > 0 {
+					recordAudit(nil, "accounts.expire", name, nil, map[string]interface{}{"Removed": report.Removed}, nil)
+				}
+			}
+		}
+	}
+}
+
+// CredentialChecks periodically verifies the credentials connectors use with
+// their sources, which is also how a rejected credential is found valid again.
+func CredentialChecks(managers []Manager, interval time.Duration) {
+	checkTimer := time.Tick(interval)
+	for {
+		select {
+		case <-checkTimer:
+			for _, c := range connectorCredentials(managers) {
+				if !c.Stale() {
+					c.Verify()
+				}
+			}
+		}
+	}
+}
+
+// connectorCredentials returns the credential of every connector, tenants'
+// included.
+func connectorCredentials(managers []Manager) []*credential.Credential {
+	var credentials []*credential.Credential
+	for _, manager := range append(TenantConnectors(managers), managers...) {
+		if manager.Type() == CONNECTOR {
+			credentials = append(credentials, manager.Credential())
+		}
+	}
+	return credentials
+}
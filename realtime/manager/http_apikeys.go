@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"realtime/apikey"
+	"realtime/audit"
+	"realtime/listener"
+)
+
+const API_KEY_HEADER = "X-Api-Key"
+
+// RequireApiKeys lets a request through only with an api key granting the
+// scopes of its route. Ingest payloads are signed by their senders and need
+// no key.
+func RequireApiKeys(keys *apikey.Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopes := scopesOf(r)
+		if len(scopes) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		deny := func(responseCode responseCodeEnum, reasonCode reasonCodeEnum) {
+			w.Header().Set("Content-Type", "application/json")
+			sendResponse(w, r, responseCode, SCAN_UNDEFINED, reasonCode)
+		}
+		// a verified client certificate stands for the key bound to its
+		// subject
+		var key apikey.Key
+		var err error
+		if token := r.Header.Get(API_KEY_HEADER); token != "" {
+			key, err = keys.Authenticate(token)
+		} else if subject, ok := listener.Subject(r); ok {
+			key, err = keys.AuthenticateSubject(subject)
+		} else {
+			deny(RESPONSE_UNAUTHORIZED, ERROR_API_KEY_MISSING)
+			return
+		}
+		if err != nil {
+			deny(RESPONSE_UNAUTHORIZED, ERROR_API_KEY_INVALID)
+			return
+		}
+		for _, scope := range scopes {
+			if !key.Allows(scope) {
+				keys.Deny(key.Id)
+				AuditLog.Record(audit.Entry{Actor: keyActor(key), Action: "request", Target: r.Method + " " + r.URL.Path, Outcome: audit.OUTCOME_DENIED, Detail: "missing scope " + scope})
+				deny(RESPONSE_FORBIDDEN, ERROR_API_KEY_SCOPE)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(apikey.NewContext(r.Context(), key)))
+	})
+}
+
+// scopesOf returns the scopes a request needs.
+func scopesOf(r *http.Request) []string {
+	path := r.URL.Path
+	read := r.Method == "GET" || r.Method == "HEAD"
+
+	switch {
+	case path == "/":
+		if read {
+			return []string{apikey.MANAGE_READ}
+		}
+		return []string{apikey.MANAGE_LIFECYCLE}
+	case strings.HasPrefix(path, "/debug/"):
+		return []string{apikey.MANAGE_READ}
+	case strings.HasPrefix(path, MANAGE_PREFIX+"/keys"):
+		return []string{apikey.KEYS_ADMIN}
+	case strings.HasPrefix(path, MANAGE_PREFIX+"/"):
+		if read {
+			return []string{apikey.MANAGE_READ}
+		}
+		// both forget accounts
+		if (r.Method == "DELETE" && strings.HasPrefix(path, MANAGE_PREFIX+"/tenants/")) ||
+			(strings.HasSuffix(path, "/_import") && r.URL.Query().Get("mode") == "replace") {
+			return []string{apikey.MANAGE_WRITE, apikey.ACCOUNTS_DELETE}
+		}
+		return []string{apikey.MANAGE_WRITE}
+	case r.Method == "POST" && strings.HasSuffix(path, "/"+INGEST_PATH):
+		return nil
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		return []string{apikey.SCAN_READ}
+	case "DELETE":
+		return []string{apikey.ACCOUNTS_DELETE}
+	case "PUT":
+		if r.URL.Query().Get("rotate") == "true" {
+			return []string{apikey.SCAN_WRITE, apikey.CREDENTIALS_ROTATE}
+		}
+	}
+	return []string{apikey.SCAN_WRITE}
+}
+
+type jsonNewApiKey struct {
+	apikey.Key
+	Token string
+}
+
+func (h *HttpManagement) handleApiKeys(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, h.Keys.List())
+}
+
+// handleApiKeyPost creates a key from {"Name": ..., "Scopes": [...]}, and
+// "Subject" to bind it to client certificates of that common name. The
+// response holds its token which is not shown again.
+func (h *HttpManagement) handleApiKeyPost(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name    string
+		Scopes  []string
+		Subject string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "body must be {\"Name\": ..., \"Scopes\": [...]}", http.StatusBadRequest)
+		return
+	}
+	if err := apikey.ValidScopes(request.Scopes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, token, err := h.Keys.Create(request.Name, request.Scopes, request.Subject)
+	recordAudit(r, "apikey.create", key.Id, nil, request.Scopes, err)
+	if err == apikey.ErrSubjectTaken {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusCreated, jsonNewApiKey{Key: key, Token: token})
+}
+
+func (h *HttpManagement) handleApiKeyDelete(w http.ResponseWriter, r *http.Request) {
+	revoked, err := h.Keys.Revoke(r.URL.Query().Get(":id"))
+	if revoked || err != nil {
+		recordAudit(r, "apikey.revoke", r.URL.Query().Get(":id"), nil, nil, err)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
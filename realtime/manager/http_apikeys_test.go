@@ -0,0 +1,44 @@
+package manager
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"realtime/apikey"
+)
+
+func TestScopesOf(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		scopes []string
+	}{
+		{"GET", "/", []string{apikey.MANAGE_READ}},
+		{"POST", "/", []string{apikey.MANAGE_LIFECYCLE}},
+		{"GET", MANAGE_PREFIX + "/keys", []string{apikey.KEYS_ADMIN}},
+		{"DELETE", MANAGE_PREFIX + "/keys/k1", []string{apikey.KEYS_ADMIN}},
+		{"GET", MANAGE_PREFIX + "/tenants", []string{apikey.MANAGE_READ}},
+		{"HEAD", MANAGE_PREFIX + "/audit", []string{apikey.MANAGE_READ}},
+		{"PUT", MANAGE_PREFIX + "/tenants/twitterstream/acme", []string{apikey.MANAGE_WRITE}},
+		{"DELETE", MANAGE_PREFIX + "/tenants/twitterstream/acme", []string{apikey.MANAGE_WRITE, apikey.ACCOUNTS_DELETE}},
+		{"POST", MANAGE_PREFIX + "/accounts/twitterstream/_import", []string{apikey.MANAGE_WRITE}},
+		{"POST", MANAGE_PREFIX + "/accounts/twitterstream/_import?mode=replace", []string{apikey.MANAGE_WRITE, apikey.ACCOUNTS_DELETE}},
+		{"POST", "/webhook/" + INGEST_PATH, nil},
+		{"GET", "/webhook/" + INGEST_PATH, []string{apikey.SCAN_READ}},
+		{"GET", "/twitterstream/1", []string{apikey.SCAN_READ}},
+		{"HEAD", "/twitterstream/1", []string{apikey.SCAN_READ}},
+		{"PUT", "/twitterstream/1", []string{apikey.SCAN_WRITE}},
+		{"PUT", "/twitterstream/1?rotate=true", []string{apikey.SCAN_WRITE, apikey.CREDENTIALS_ROTATE}},
+		{"PUT", "/twitterstream/1?rotate=false", []string{apikey.SCAN_WRITE}},
+		{"DELETE", "/twitterstream/1", []string{apikey.ACCOUNTS_DELETE}},
+		{"GET", "/debug/pprof/", []string{apikey.MANAGE_READ}},
+		{"POST", "/debug/pprof/symbol", []string{apikey.MANAGE_READ}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if scopes := scopesOf(r); !reflect.DeepEqual(scopes, tt.scopes) {
+			t.Errorf("scopesOf(%s %s) = %v, want %v", tt.method, tt.target, scopes, tt.scopes)
+		}
+	}
+}
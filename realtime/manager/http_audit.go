@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"realtime/apikey"
+	"realtime/audit"
+	"realtime/listener"
+)
+
+// AuditLog records management and account-changing actions, nothing is
+// recorded while it is nil.
+var AuditLog *audit.Log
+
+// actorOf names who made a request, by api key when it carried one and by
+// client certificate when it presented one.
+func actorOf(r *http.Request) string {
+	if key, ok := apikey.FromContext(r.Context()); ok {
+		return keyActor(key)
+	}
+	if subject, ok := listener.Subject(r); ok {
+		return "cert:" + subject
+	}
+	return addressOf(r)
+}
+
+func addressOf(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+func keyActor(key apikey.Key) string {
+	return "key:" + key.Id + "(" + key.Name + ")"
+}
+
+// credentialTarget names the credential of a property or of one of its
+// tenants, the way tenant stores are named.
+func credentialTarget(property string, tenant_id string) string {
+	if tenant_id == "" {
+		return property
+	}
+	return property + "@" + tenant_id
+}
+
+func recordAudit(r *http.Request, action string, target string, before interface{}, after interface{}, err error) {
+	actor := audit.ACTOR_SYSTEM
+	if r != nil {
+		actor = actorOf(r)
+	}
+	e := audit.Entry{Actor: actor, Action: action, Target: target, Before: before, After: after, Outcome: audit.OUTCOME_OK}
+	if err != nil {
+		e.Outcome = audit.OUTCOME_FAILED
+		e.Detail = err.Error()
+	}
+	AuditLog.Record(e)
+}
+
+// handleAudit answers ?since=&until= (RFC 3339), ?actor=, ?action=,
+// ?target= and ?limit=.
+func (h *HttpManagement) handleAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{Actor: query.Get("actor"), Action: query.Get("action"), Target: query.Get("target")}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	entries, err := AuditLog.Query(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, entries)
+}
@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"net/http"
+	"time"
+)
+
+type jsonConsumer struct {
+	Name     string
+	Id       uint32
+	Created  time.Time
+	LastSeen time.Time
+	Ttl      string
+}
+
+func (h *HttpManagement) handleConsumers(w http.ResponseWriter, r *http.Request) {
+	response := make(map[string][]jsonConsumer)
+	for name, store := range Stores(*h.Managed) {
+		list := make([]jsonConsumer, 0)
+		for _, consumer := range store.Consumers() {
+			list = append(list, jsonConsumer{
+				Name:     consumer.Name,
+				Id:       consumer.Id,
+				Created:  consumer.Created,
+				LastSeen: consumer.LastSeen,
+				Ttl:      consumer.Ttl.String(),
+			})
+		}
+		response[name] = list
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+// handleConsumerPut registers a consumer, ?ttl= (e.g. 72h) sets how long it
+// may go without scanning before it expires.
+func (h *HttpManagement) handleConsumerPut(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	store, present := Stores(*h.Managed)[query.Get(":property")]
+	if !present {
+		http.NotFound(w, r)
+		return
+	}
+	var ttl time.Duration
+	if value := query.Get("ttl"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "ttl must be a positive duration", http.StatusBadRequest)
+			return
+		}
+	}
+	consumer := store.RegisterConsumer(query.Get(":name"), ttl)
+	recordAudit(r, "consumer.register", query.Get(":property")+"/"+consumer.Name, nil, consumer.Ttl.String(), nil)
+	writeJson(w, http.StatusOK, jsonConsumer{
+		Name:     consumer.Name,
+		Id:       consumer.Id,
+		Created:  consumer.Created,
+		LastSeen: consumer.LastSeen,
+		Ttl:      consumer.Ttl.String(),
+	})
+}
+
+func (h *HttpManagement) handleConsumerDelete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	store, present := Stores(*h.Managed)[query.Get(":property")]
+	if !present || !store.RemoveConsumer(query.Get(":name")) {
+		http.NotFound(w, r)
+		return
+	}
+	recordAudit(r, "consumer.remove", query.Get(":property")+"/"+query.Get(":name"), nil, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"net/http"
+	"time"
+
+	"realtime/credential"
+)
+
+type jsonCredential struct {
+	Tenant        string `json:",omitempty"`
+	Credential    string
+	Version       int
+	Source        string `json:",omitempty"`
+	Stale         bool
+	Health        credential.HealthEnum
+	HealthReason  string     `json:",omitempty"`
+	HealthChecked *time.Time `json:",omitempty"`
+	FallbackUntil *time.Time `json:",omitempty"`
+	Rotations     []credential.RotationEvent
+}
+
+func credentialJson(tenant string, c *credential.Credential) jsonCredential {
+	j := jsonCredential{
+		Tenant:     tenant,
+		Credential: c.Json().Id(),
+		Version:    c.Version(),
+		Source:     c.Source(),
+		Stale:      c.Stale(),
+		Rotations:  c.Rotations(),
+	}
+	health, reason, checked := c.Health()
+	j.Health, j.HealthReason = health, reason
+	if !checked.IsZero() {
+		j.HealthChecked = &checked
+	}
+	if until := c.FallbackUntil(); !until.IsZero() {
+		j.FallbackUntil = &until
+	}
+	return j
+}
+
+// credentialOf returns the credential of property, or of its tenant when one
+// is named.
+func (h *HttpManagement) credentialOf(property string, tenant_id string) (*credential.Credential, bool) {
+	if tenant_id != "" {
+		tenants, present := h.tenantsOf(property)
+		if !present {
+			return nil, false
+		}
+		tenant, present := tenants.ById(tenant_id)
+		if !present {
+			return nil, false
+		}
+		return tenant.Credential(), true
+	}
+	for _, manager := range *h.Managed {
+		if manager.Name() == property {
+			return manager.Credential(), true
+		}
+	}
+	return nil, false
+}
+
+// handleCredentials shows which credential every property and tenant uses,
+// abbreviated, with its recent rotations.
+func (h *HttpManagement) handleCredentials(w http.ResponseWriter, r *http.Request) {
+	response := make(map[string][]jsonCredential)
+	for _, manager := range *h.Managed {
+		if _, seen := response[manager.Name()]; seen {
+			continue
+		}
+		list := []jsonCredential{credentialJson("", manager.Credential())}
+		if m, ok := manager.(tenanted); ok && m.Tenants() != nil {
+			for _, tenant := range m.Tenants().List() {
+				list = append(list, credentialJson(tenant.Id, tenant.Credential()))
+			}
+		}
+		response[manager.Name()] = list
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+// handleCredentialRotate rotates the credential of a property, or of the
+// tenant named by ?tenant=, to the one in the body. ?fallback= sets how long
+// the previous credential stays usable.
+func (h *HttpManagement) handleCredentialRotate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tenant_id := query.Get("tenant")
+	c, present := h.credentialOf(query.Get(":property"), tenant_id)
+	if !present {
+		http.NotFound(w, r)
+		return
+	}
+	var window time.Duration
+	if value := query.Get("fallback"); value != "" {
+		var err error
+		window, err = time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			http.Error(w, "fallback must be a positive duration", http.StatusBadRequest)
+			return
+		}
+	}
+	json_credential := credential.CredentialFromJson(r.Body)
+	if json_credential == nil {
+		http.Error(w, "credential is not valid", http.StatusBadRequest)
+		return
+	}
+	before := c.Json().Id()
+	err := c.Rotate(json_credential, window)
+	recordAudit(r, "credential.rotate", credentialTarget(query.Get(":property"), tenant_id), before, json_credential.Id(), err)
+	switch err {
+	case nil:
+		writeJson(w, http.StatusOK, credentialJson(tenant_id, c))
+	case credential.ErrRotationInvalid:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case credential.ErrRotationUnchanged, credential.ErrRotationBusy:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		// the source did not accept the credential
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"realtime/deadletter"
+)
+
+type jsonDeadLetter struct {
+	Id       int64
+	Property string
+	Reason   deadletter.Reason
+	Detail   string
+	Received time.Time
+	Size     int
+}
+
+type jsonDeadLetters struct {
+	Counts  map[string]int64
+	Letters []jsonDeadLetter
+}
+
+// handleDeadLetters lists the quarantined letters without their content,
+// ?property= and ?reason= filter the list.
+func (h *HttpManagement) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	letters := h.DeadLetters.Letters(query.Get("property"), deadletter.Reason(query.Get("reason")))
+
+	response := jsonDeadLetters{Counts: h.DeadLetters.Counts(), Letters: make([]jsonDeadLetter, 0, len(letters))}
+	for _, letter := range letters {
+		response.Letters = append(response.Letters, jsonDeadLetter{
+			Id:       letter.Id,
+			Property: letter.Property,
+			Reason:   letter.Reason,
+			Detail:   letter.Detail,
+			Received: letter.Received,
+			Size:     len(letter.Raw),
+		})
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+// handleDeadLetter downloads the raw content of one letter.
+func (h *HttpManagement) handleDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		http.Error(w, "letter id must be a number", http.StatusBadRequest)
+		return
+	}
+	letter, present := h.DeadLetters.Letter(id)
+	if !present {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=deadletter-"+strconv.FormatInt(id, 10)+".raw")
+	w.Header().Set("X-Dead-Letter-Reason", string(letter.Reason))
+	w.Write(letter.Raw)
+}
+
+// handleDeadLettersExport downloads the letters held in memory, including
+// their content, as newline delimited json.
+func (h *HttpManagement) handleDeadLettersExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	letters := h.DeadLetters.Letters(query.Get("property"), deadletter.Reason(query.Get("reason")))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=deadletter.ndjson")
+	enc := json.NewEncoder(w)
+	for _, letter := range letters {
+		if err := enc.Encode(letter); err != nil {
+			return
+		}
+	}
+}
+
+func (h *HttpManagement) handleDeadLettersClear(w http.ResponseWriter, r *http.Request) {
+	h.DeadLetters.Clear()
+	recordAudit(r, "deadletter.clear", "", nil, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
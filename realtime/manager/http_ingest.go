@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"engines/github.com.garyburd.go-oauth/oauth"
+
+	"realtime/account_entry"
+	"realtime/deadletter"
+	"realtime/jsonpath"
+)
+
+func (b *BaseIngest) IngestHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !b.State().Up() {
+		sendResponse(w, r, RESPONSE_UNAVAILABLE, SCAN_UNDEFINED, ERROR_INGEST_DOWN)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, INGEST_MAX_BYTES+1))
+	if err != nil || len(body) > INGEST_MAX_BYTES {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_PAYLOAD_UNREADABLE)
+		return
+	}
+
+	if reasonCode, ok := b.verify(r, body, time.Now()); !ok {
+		b.Logger.Warningf("rejected ingest from %s: %s\n", r.RemoteAddr, reasonCode)
+		sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, reasonCode)
+		return
+	}
+
+	// a payload with trailing bytes decodes, but is no JSON to serve items of
+	if !json.Valid(body) {
+		b.Quarantine(deadletter.UNPARSABLE, "payload is not valid JSON", body)
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_JSON_UNPARSABLE)
+		return
+	}
+	account_ids, err := jsonpath.Collect(body, b.config.AccountIdPath)
+	if err != nil {
+		b.Quarantine(deadletter.UNPARSABLE, err.Error(), body)
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_JSON_UNPARSABLE)
+		return
+	}
+	if len(account_ids) == 0 {
+		b.Quarantine(deadletter.UNROUTABLE, "no account ids at "+b.config.AccountIdPath, body)
+	}
+
+	store := b.Store()
+	matched := 0
+	for _, account_id := range account_ids {
+		account, account_present := store.AccountEntry(account_id)
+		if account_present {
+			store.RecordItem(account, account_entry.KIND_POST, body)
+			matched += 1
+		}
+	}
+	b.Logger.Debugf("ingested %d account ids, %d monitored\n", len(account_ids), matched)
+	sendResponse(w, r, RESPONSE_ACCEPTED, SCAN_UNDEFINED, REASON_INGEST_ACCEPTED)
+}
+
+func (b *BaseIngest) verify(r *http.Request, body []byte, now time.Time) (reasonCodeEnum, bool) {
+	authorization := r.Header.Get("Authorization")
+	signature := r.Header.Get("X-Signature")
+
+	if len(b.config.OauthConsumers)+len(b.config.OauthRsaConsumers) > 0 && strings.HasPrefix(authorization, "OAuth ") {
+		return b.verifyOauth(r, body)
+	}
+	if b.config.HmacSecret != "" && signature != "" {
+		return b.verifyHmac(r, signature, body, now)
+	}
+	return ERROR_SIGNATURE_MISSING, false
+}
+
+// verifyHmac checks X-Signature: sha256=<hex> computed over
+// "<X-Timestamp>.<X-Nonce>.<body>" with the shared secret.
+func (b *BaseIngest) verifyHmac(r *http.Request, signature string, body []byte, now time.Time) (reasonCodeEnum, bool) {
+	timestamp := r.Header.Get("X-Timestamp")
+	nonce := r.Header.Get("X-Nonce")
+
+	if !strings.HasPrefix(signature, "sha256=") {
+		return ERROR_SIGNATURE_INVALID, false
+	}
+	sent, err := hex.DecodeString(signature[len("sha256="):])
+	if err != nil {
+		return ERROR_SIGNATURE_INVALID, false
+	}
+
+	mac := hmac.New(sha256.New, []byte(b.config.HmacSecret))
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+	if !hmac.Equal(sent, mac.Sum(nil)) {
+		return ERROR_SIGNATURE_INVALID, false
+	}
+	return b.checkReplay(timestamp, "hmac:"+nonce, nonce == "", now)
+}
+
+// verifyOauth checks a two-legged OAuth 1.0a signature. Non-form bodies are
+// only covered by the signature through oauth_body_hash, so it is required for
+// them.
+func (b *BaseIngest) verifyOauth(r *http.Request, body []byte) (reasonCodeEnum, bool) {
+	_, err := b.oauth.Verify(r.Method, b.requestUrl(r), r.Header, body)
+	switch err {
+	case nil:
+		return REASON_INGEST_ACCEPTED, true
+	case oauth.ErrTimestamp:
+		return ERROR_REQUEST_EXPIRED, false
+	case oauth.ErrNonce:
+		return ERROR_NONCE_REPLAYED, false
+	}
+	return ERROR_SIGNATURE_INVALID, false
+}
+
+func (b *BaseIngest) checkReplay(timestamp string, nonce_key string, nonce_empty bool, now time.Time) (reasonCodeEnum, bool) {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce_empty {
+		return ERROR_SIGNATURE_INVALID, false
+	}
+	skew := now.Sub(time.Unix(secs, 0))
+	if skew > b.config.MaxSkew || skew < -b.config.MaxSkew {
+		return ERROR_REQUEST_EXPIRED, false
+	}
+	if !b.oauth.UseNonce(nonce_key, now) {
+		return ERROR_NONCE_REPLAYED, false
+	}
+	return REASON_INGEST_ACCEPTED, true
+}
+
+// requestUrl rebuilds the absolute url the client signed, through a trusted
+// proxy the scheme is the one the proxy was reached with.
+func (b *BaseIngest) requestUrl(r *http.Request) *url.URL {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); (proto == "http" || proto == "https") && b.fromTrustedProxy(r) {
+		scheme = proto
+	}
+	return &url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+}
+
+func (b *BaseIngest) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, proxy := range b.config.TrustedProxies {
+		if ip != nil && proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
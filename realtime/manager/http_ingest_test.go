@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"engines/github.com.garyburd.go-oauth/oauth"
+)
+
+func signHmac(secret string, timestamp string, nonce string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + nonce + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHmac(t *testing.T) {
+	const skew = time.Minute
+	b := &BaseIngest{config: IngestConfig{HmacSecret: "secret", MaxSkew: skew}, oauth: &oauth.Server{MaxSkew: skew}}
+	start := time.Unix(1500000000, 0)
+	body := `{"account_ids":["1"]}`
+
+	// steps run in order against one nonce store, the clock is at start
+	// plus after and the request is signed at start plus signed
+	steps := []struct {
+		after     time.Duration
+		signed    time.Duration
+		nonce     string
+		signature string
+		reason    reasonCodeEnum
+	}{
+		{0, 0, "a", "", REASON_INGEST_ACCEPTED},
+		{time.Second, 0, "a", "", ERROR_NONCE_REPLAYED},
+		{time.Second, 0, "b", "sha256=00", ERROR_SIGNATURE_INVALID},
+		{time.Second, 0, "b", "md5=00", ERROR_SIGNATURE_INVALID},
+		{time.Second, 0, "", "", ERROR_SIGNATURE_INVALID},
+		{skew + time.Second, 0, "b", "", ERROR_REQUEST_EXPIRED},
+		{0, skew + time.Second, "c", "", ERROR_REQUEST_EXPIRED},
+		{0, skew, "c", "", REASON_INGEST_ACCEPTED},
+		// a nonce is remembered for at least twice the skew, by then its
+		// timestamp has expired anyway
+		{2*skew - time.Second, 2 * skew, "a", "", ERROR_NONCE_REPLAYED},
+		{4 * skew, 4 * skew, "a", "", REASON_INGEST_ACCEPTED},
+	}
+	for i, step := range steps {
+		timestamp := strconv.FormatInt(start.Add(step.signed).Unix(), 10)
+		signature := step.signature
+		if signature == "" {
+			signature = signHmac("secret", timestamp, step.nonce, body)
+		}
+		r := httptest.NewRequest("POST", "/webhook/"+INGEST_PATH, nil)
+		r.Header.Set("X-Timestamp", timestamp)
+		r.Header.Set("X-Nonce", step.nonce)
+		if reason, _ := b.verifyHmac(r, signature, []byte(body), start.Add(step.after)); reason != step.reason {
+			t.Errorf("step %d: verifyHmac() = %q, want %q", i, reason, step.reason)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"net/http"
+
+	"realtime/itembuffer"
+	"realtime/state"
+)
+
+const ITEMS_PATH = "items"
+
+type jsonItemsResponse struct {
+	jsonResponse
+	Sequence uint64
+	Items    []itembuffer.Item
+}
+
+// ItemsHandler serves GET /<property>/:id/items?since=<seq>, the buffered raw
+// content of an account. Without since it returns what arrived after the
+// consumer's last scan. The buffer is bounded, a scanner that finds fewer
+// items than expected has to fetch the rest from the source.
+func (b *BaseRouter) ItemsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	s := b.State()
+
+	if *s.State() != state.UP {
+		sendResponse(w, r, RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ROUTE_DOWN)
+		return
+	}
+	store, _, known := b.tenantOf(r)
+	if !known {
+		sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_TENANT_UNKNOWN)
+		return
+	}
+	if !store.ItemsBuffered() {
+		sendResponse(w, r, RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ITEMS_DISABLED)
+		return
+	}
+	since, valid := sinceParam(r)
+	if !valid {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_SINCE_INVALID)
+		return
+	}
+	consumer, known := consumerParam(r, store)
+	if !known {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_CONSUMER_UNKNOWN)
+		return
+	}
+	account_id := r.URL.Query().Get(":id")
+	account, account_present := store.AccountEntry(account_id)
+	if !account_present {
+		sendResponse(w, r, RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ACCOUNT_NOT_MONITORED)
+		return
+	}
+	if since == nil {
+		last_scan := account.LastScanSeqFor(consumer)
+		since = &last_scan
+	}
+
+	j_response := jsonItemsResponse{jsonResponse: jsonResponse{Code: RESPONSE_OK}}
+	j_response.Sequence = account.LastUpdateSeq()
+	j_response.Items = store.Items(account_id, *since)
+	writeJson(w, int(RESPONSE_OK), j_response)
+}
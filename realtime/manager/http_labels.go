@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"net/http"
+	"strconv"
+
+	"realtime/account_store"
+	"realtime/state"
+)
+
+const LABELS_PATH = "_labels"
+
+type jsonLabelAccount struct {
+	Id       string
+	Scan     string
+	Reason   string
+	State    string
+	Kinds    []string `json:",omitempty"`
+	Sequence uint64   `json:",omitempty"`
+}
+
+type jsonLabelResponse struct {
+	jsonResponse
+	Total    int
+	Updated  int
+	States   map[string]int
+	Accounts []jsonLabelAccount
+}
+
+type jsonLabelValues struct {
+	jsonResponse
+	Values map[string]int
+}
+
+// LabelValuesHandler serves GET /<property>/_labels/:key, the values of a
+// label with the number of accounts carrying each.
+func (b *BaseRouter) LabelValuesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if *b.State().State() != state.UP {
+		sendResponse(w, r, RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ROUTE_DOWN)
+		return
+	}
+	store, _, known := b.tenantOf(r)
+	if !known {
+		sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_TENANT_UNKNOWN)
+		return
+	}
+	values := store.LabelValues(r.URL.Query().Get(":key"))
+	writeJson(w, int(RESPONSE_OK), jsonLabelValues{jsonResponse: jsonResponse{Code: RESPONSE_OK}, Values: values})
+}
+
+// LabelHandler serves the accounts labelled :key::value. GET aggregates
+// their scan status, ?updated=true lists only those with new content. PUT
+// ?priority=N reprioritizes and DELETE stops monitoring all of them.
+func (b *BaseRouter) LabelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	s := b.State()
+	query := r.URL.Query()
+	key, value := query.Get(":key"), query.Get(":value")
+
+	if *s.State() != state.UP {
+		sendResponse(w, r, RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ROUTE_DOWN)
+		return
+	}
+	store, _, known := b.tenantOf(r)
+	if !known {
+		sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_TENANT_UNKNOWN)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+	case "PUT":
+		priority, err := strconv.Atoi(query.Get("priority"))
+		if err != nil {
+			sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_PRIORITY_INVALID)
+			return
+		}
+		b.Logger.Infof("set priority %d on %d accounts labelled %s:%s\n", priority, store.SetPriorityByLabel(key, value, priority), key, value)
+	case "DELETE":
+		removed := store.RemoveByLabel(key, value)
+		recordAudit(r, "accounts.remove", string(store.Property)+"/"+LABELS_PATH+"/"+key+":"+value, nil, map[string]interface{}{"Removed": removed}, nil)
+		b.Logger.Infof("stopped monitoring %d accounts labelled %s:%s\n", len(removed), key, value)
+		writeJson(w, int(RESPONSE_OK), jsonLabelResponse{jsonResponse: jsonResponse{Code: RESPONSE_OK}, Total: len(removed), States: map[string]int{}, Accounts: []jsonLabelAccount{}})
+		return
+	default:
+		sendResponse(w, r, RESPONSE_NOT_ALLOWED, SCAN_UNDEFINED, ERROR_TRY_ANOTHER_METHOD)
+		return
+	}
+
+	consumer, known := consumerParam(r, store)
+	if !known {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_CONSUMER_UNKNOWN)
+		return
+	}
+	since, valid := sinceParam(r)
+	if !valid {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_SINCE_INVALID)
+		return
+	}
+	only_updated := query.Get("updated") == "true"
+
+	j_response := jsonLabelResponse{jsonResponse: jsonResponse{Code: RESPONSE_OK}, States: make(map[string]int), Accounts: make([]jsonLabelAccount, 0)}
+	for _, account_id := range store.AccountsByLabel(key, value) {
+		account, account_present := store.AccountEntry(account_id)
+		if !account_present {
+			continue
+		}
+		scanCode, reasonCode := scanCodeAndReason(s, account, consumer, since)
+		account_state := account.State()
+		j_response.Total += 1
+		j_response.States[account_state.String()] += 1
+		if scanCode == SCAN_YES {
+			j_response.Updated += 1
+		} else if only_updated {
+			continue
+		}
+		j_response.Accounts = append(j_response.Accounts, jsonLabelAccount{
+			Id:       account_id,
+			Scan:     string(scanCode),
+			Reason:   string(reasonCode),
+			State:    account_state.String(),
+			Kinds:    account.PendingKindsFor(consumer).Names(),
+			Sequence: account.LastUpdateSeq(),
+		})
+	}
+	writeJson(w, int(RESPONSE_OK), j_response)
+}
+
+// labelsParam parses the repeated label=key:value parameters of a PUT. More
+// labels than an account carries are refused here, before the PUT creates
+// the account.
+func labelsParam(r *http.Request) (map[string]string, bool) {
+	list := r.URL.Query()["label"]
+	if len(list) == 0 {
+		return nil, true
+	}
+	labels := make(map[string]string, len(list))
+	for _, label := range list {
+		key, value, err := account_store.ParseLabel(label)
+		if err != nil {
+			return nil, false
+		}
+		labels[key] = value
+	}
+	set := 0
+	for _, value := range labels {
+		if value != "" {
+			set += 1
+		}
+	}
+	return labels, set <= account_store.MAX_LABELS
+}
@@ -0,0 +1,30 @@
+package manager
+
+import (
+	"net/http"
+	"time"
+
+	"realtime/account_store"
+)
+
+type jsonLifecycle struct {
+	DormantAfter string
+	RemoveAfter  string
+	Report       account_store.LifecycleReport
+}
+
+// handleLifecycle reports, without changing anything, which accounts the
+// next lifecycle collection would make dormant or remove.
+func (h *HttpManagement) handleLifecycle(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	response := make(map[string]jsonLifecycle)
+	for name, store := range Stores(*h.Managed) {
+		policy := store.LifecyclePolicy()
+		response[name] = jsonLifecycle{
+			DormantAfter: policy.DormantAfter.String(),
+			RemoveAfter:  policy.RemoveAfter.String(),
+			Report:       store.Collect(now, true),
+		}
+	}
+	writeJson(w, http.StatusOK, response)
+}
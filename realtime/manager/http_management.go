@@ -1,14 +1,24 @@
 package manager
 
 import (
+	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
 
 	"engines/github.com.bmizerany.pat"
+
+	"realtime/apikey"
+	"realtime/deadletter"
+	"realtime/itembuffer"
+	"realtime/ratelimit"
+	"realtime/state"
 )
 
+const MANAGE_PREFIX = "/_manage"
+
 var templates = make(map[template.Template]*template.Template)
 
 func init() {
@@ -16,7 +26,11 @@ func init() {
 }
 
 type HttpManagement struct {
-	Managed *[]Manager
+	Managed     *[]Manager
+	DeadLetters *deadletter.Store
+	Items       *itembuffer.Buffer
+	Keys        *apikey.Store
+	Limiter     *ratelimit.Limiter
 }
 
 func NewHttpManagement(managed *[]Manager) *HttpManagement {
@@ -26,6 +40,34 @@ func NewHttpManagement(managed *[]Manager) *HttpManagement {
 }
 
 func (h *HttpManagement) SetRoutes(pat *pat.PatternServeMux) {
+	pat.Get(MANAGE_PREFIX+"/metrics", http.HandlerFunc(h.handleMetrics))
+	pat.Get(MANAGE_PREFIX+"/lifecycle", http.HandlerFunc(h.handleLifecycle))
+	pat.Get(MANAGE_PREFIX+"/accounts/:property/_export", http.HandlerFunc(h.handleExport))
+	pat.Post(MANAGE_PREFIX+"/accounts/:property/_import", http.HandlerFunc(h.handleImport))
+	pat.Get(MANAGE_PREFIX+"/tenants", http.HandlerFunc(h.handleTenants))
+	pat.Put(MANAGE_PREFIX+"/tenants/:property/:id", http.HandlerFunc(h.handleTenantPut))
+	pat.Del(MANAGE_PREFIX+"/tenants/:property/:id", http.HandlerFunc(h.handleTenantDelete))
+	pat.Get(MANAGE_PREFIX+"/credentials", http.HandlerFunc(h.handleCredentials))
+	pat.Put(MANAGE_PREFIX+"/credentials/:property/_rotate", http.HandlerFunc(h.handleCredentialRotate))
+	pat.Get(MANAGE_PREFIX+"/consumers", http.HandlerFunc(h.handleConsumers))
+	pat.Put(MANAGE_PREFIX+"/consumers/:property/:name", http.HandlerFunc(h.handleConsumerPut))
+	pat.Del(MANAGE_PREFIX+"/consumers/:property/:name", http.HandlerFunc(h.handleConsumerDelete))
+	if h.Keys != nil {
+		pat.Get(MANAGE_PREFIX+"/keys", http.HandlerFunc(h.handleApiKeys))
+		pat.Post(MANAGE_PREFIX+"/keys", http.HandlerFunc(h.handleApiKeyPost))
+		pat.Del(MANAGE_PREFIX+"/keys/:id", http.HandlerFunc(h.handleApiKeyDelete))
+	}
+	if h.Limiter != nil {
+		pat.Get(MANAGE_PREFIX+"/ratelimit", http.HandlerFunc(h.handleRateLimit))
+		pat.Put(MANAGE_PREFIX+"/ratelimit", http.HandlerFunc(h.handleRateLimitPut))
+	}
+	if h.DeadLetters != nil {
+		pat.Get(MANAGE_PREFIX+"/deadletter", http.HandlerFunc(h.handleDeadLetters))
+		pat.Del(MANAGE_PREFIX+"/deadletter", http.HandlerFunc(h.handleDeadLettersClear))
+		pat.Get(MANAGE_PREFIX+"/deadletter/_export", http.HandlerFunc(h.handleDeadLettersExport))
+		pat.Get(MANAGE_PREFIX+"/deadletter/:id", http.HandlerFunc(h.handleDeadLetter))
+	}
+	pat.Get(MANAGE_PREFIX+"/audit", http.HandlerFunc(h.handleAudit))
 	pat.Get("/", http.HandlerFunc(h.HttpHandler))
 	pat.Post("/", http.HandlerFunc(h.HttpHandler))
 }
@@ -44,10 +86,15 @@ func (h *HttpManagement) handlePost(w http.ResponseWriter, r *http.Request) {
 
 	m := (*h.Managed)[idx]
 
+	before := string(*m.State().State())
 	if action == "shutdown" && m.State().Up() {
 		go Stop(m)
+		recordAudit(r, "connector.shutdown", m.Name(), before, string(state.SHUTDOWN), nil)
 	} else if action == "startup" && m.State().Down() {
 		go Start(m)
+		recordAudit(r, "connector.startup", m.Name(), before, string(state.STARTUP), nil)
+	} else {
+		recordAudit(r, "connector."+action, m.Name(), before, nil, errors.New("not possible while "+before))
 	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -59,3 +106,9 @@ func (h *HttpManagement) handleGet(w http.ResponseWriter, r *http.Request) {
 	}
 	t.Execute(w, *h)
 }
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
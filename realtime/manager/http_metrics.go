@@ -0,0 +1,32 @@
+package manager
+
+import (
+	"net/http"
+
+	"realtime/credential"
+	"realtime/itembuffer"
+)
+
+type jsonMetrics struct {
+	DeadLetters      map[string]int64  `json:",omitempty"`
+	ItemBuffer       *itembuffer.Stats `json:",omitempty"`
+	CredentialHealth map[credential.HealthEnum]int64
+}
+
+// handleMetrics reports the counters of the shared stores.
+func (h *HttpManagement) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var response jsonMetrics
+	response.CredentialHealth = make(map[credential.HealthEnum]int64)
+	for _, c := range connectorCredentials(*h.Managed) {
+		health, _, _ := c.Health()
+		response.CredentialHealth[health]++
+	}
+	if h.DeadLetters != nil {
+		response.DeadLetters = h.DeadLetters.Counts()
+	}
+	if h.Items != nil {
+		stats := h.Items.Stats()
+		response.ItemBuffer = &stats
+	}
+	writeJson(w, http.StatusOK, response)
+}
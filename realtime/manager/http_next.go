@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/account_entry"
+	"realtime/state"
+)
+
+const (
+	NEXT_PATH               = "_next"
+	NEXT_DEFAULT_LIMIT      = 10
+	NEXT_MAX_LIMIT          = 1000
+	NEXT_DEFAULT_VISIBILITY = 60 * time.Second
+	NEXT_MAX_VISIBILITY     = 1 * time.Hour
+)
+
+type jsonNextAccount struct {
+	Id           string
+	Sequence     uint64
+	Kinds        []string `json:",omitempty"`
+	LeaseExpires time.Time
+}
+
+type jsonNextResponse struct {
+	jsonResponse
+	Accounts []jsonNextAccount
+	Pending  int
+}
+
+// NextHandler serves GET /<property>/_next?limit=N&visibility=30s. It leases
+// the accounts with the oldest unscanned new content to the caller, the
+// scanner acknowledges an account with the usual PUT and unacknowledged
+// accounts are handed out again once the visibility timeout passes.
+func (b *BaseRouter) NextHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	s := b.State()
+
+	if *s.State() != state.UP {
+		sendResponse(w, r, RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ROUTE_DOWN)
+		return
+	}
+	store, _, known := b.tenantOf(r)
+	if !known {
+		sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_TENANT_UNKNOWN)
+		return
+	}
+	if consumer, known := consumerParam(r, store); !known || consumer != account_entry.DEFAULT_CONSUMER {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_CONSUMER_UNSUPPORTED)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := NEXT_DEFAULT_LIMIT
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_LIMIT_INVALID)
+			return
+		}
+		limit = n
+	}
+	if limit > NEXT_MAX_LIMIT {
+		limit = NEXT_MAX_LIMIT
+	}
+	visibility := NEXT_DEFAULT_VISIBILITY
+	if value := query.Get("visibility"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > NEXT_MAX_VISIBILITY {
+			sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_VISIBILITY_INVALID)
+			return
+		}
+		visibility = d
+	}
+
+	leases := store.LeaseUpdated(limit, visibility)
+	j_response := jsonNextResponse{jsonResponse: jsonResponse{Code: RESPONSE_OK}, Accounts: make([]jsonNextAccount, 0, len(leases))}
+	for _, lease := range leases {
+		account, account_present := store.AccountEntry(lease.AccountId)
+		if !account_present {
+			continue
+		}
+		j_response.Accounts = append(j_response.Accounts, jsonNextAccount{
+			Id:           lease.AccountId,
+			Sequence:     lease.Sequence,
+			Kinds:        account.PendingKinds().Names(),
+			LeaseExpires: lease.Expires,
+		})
+	}
+	j_response.Pending = store.UpdatedCount()
+
+	json_bytes, err := json.Marshal(j_response)
+	if err != nil {
+		syslog.Alertf("Unable to jsonMarshal next accounts, error '%s'\n", err)
+		sendResponse(w, r, RESPONSE_INTERNAL_ERROR, SCAN_UNDEFINED, ERROR_ACCOUNT_CANNOT_STORE)
+		return
+	}
+	w.WriteHeader(int(RESPONSE_OK))
+	w.Write(json_bytes)
+}
@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"realtime/listener"
+	"realtime/ratelimit"
+)
+
+const (
+	ROUTE_SCAN   = "scan"
+	ROUTE_ITEMS  = "items"
+	ROUTE_NEXT   = "next"
+	ROUTE_LABELS = "labels"
+	ROUTE_INGEST = "ingest"
+	ROUTE_MANAGE = "manage"
+	// ROUTE_UNAUTHENTICATED limits the addresses of requests that present
+	// neither an api key nor a client certificate, before RequireApiKeys.
+	ROUTE_UNAUTHENTICATED = "unauthenticated"
+)
+
+// LimitAddresses sheds requests once the process has its maximum in flight,
+// management requests are never shed so an overloaded instance can still be
+// looked into. It runs before RequireApiKeys, where only requests that present
+// neither an api key nor a client certificate are limited, by address on the
+// unauthenticated route against abuse. Clients are limited on their route by
+// RateLimit.
+func LimitAddresses(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routeOf(r) != ROUTE_MANAGE {
+			if !limiter.Acquire() {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "1")
+				sendResponse(w, r, RESPONSE_UNAVAILABLE, SCAN_UNDEFINED, ERROR_OVERLOADED)
+				return
+			}
+			defer limiter.Release()
+		}
+		if !authenticates(r) && !allow(limiter, addressOf(r), ROUTE_UNAUTHENTICATED, w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit turns away clients over their limit on a route with 429, by api
+// key when the request has one and by client certificate or address
+// otherwise. It runs inside RequireApiKeys so a key's limit in Config.Clients
+// applies whatever address it is used from.
+func RateLimit(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(limiter, actorOf(r), routeOf(r), w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticates tells whether a request presents an api key or a verified
+// client certificate, whether RequireApiKeys accepts it or not.
+func authenticates(r *http.Request) bool {
+	if r.Header.Get(API_KEY_HEADER) != "" {
+		return true
+	}
+	_, ok := listener.Subject(r)
+	return ok
+}
+
+func allow(limiter *ratelimit.Limiter, client string, route string, w http.ResponseWriter, r *http.Request) bool {
+	allowed, wait := limiter.Allow(client, route, time.Now())
+	if !allowed {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		sendResponse(w, r, RESPONSE_RATE_LIMITED, SCAN_UNDEFINED, ERROR_RATE_LIMITED)
+	}
+	return allowed
+}
+
+// routeOf names the route a request is limited on. pprof is served on its own
+// listener, should /debug/ reach the api it is a management route as it is
+// for scopesOf.
+func routeOf(r *http.Request) string {
+	path := r.URL.Path
+	if path == "/" || strings.HasPrefix(path, MANAGE_PREFIX+"/") || strings.HasPrefix(path, "/debug/") {
+		return ROUTE_MANAGE
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 2 {
+		switch parts[1] {
+		case NEXT_PATH:
+			return ROUTE_NEXT
+		case LABELS_PATH:
+			return ROUTE_LABELS
+		case INGEST_PATH:
+			return ROUTE_INGEST
+		}
+	}
+	if len(parts) >= 3 && parts[2] == ITEMS_PATH {
+		return ROUTE_ITEMS
+	}
+	return ROUTE_SCAN
+}
+
+type jsonRateLimit struct {
+	ratelimit.Config
+	Stats ratelimit.Stats
+}
+
+func (h *HttpManagement) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, jsonRateLimit{Config: h.Limiter.Config(), Stats: h.Limiter.Stats()})
+}
+
+// handleRateLimitPut replaces the limits with the config in the body, e.g.
+// {"Routes": {"scan": {"Rate": 20, "Burst": 40}}, "MaxConcurrent": 200}.
+func (h *HttpManagement) handleRateLimitPut(w http.ResponseWriter, r *http.Request) {
+	var config ratelimit.Config
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, "body must be a rate limit config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := config.Valid(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	before := h.Limiter.Config()
+	h.Limiter.SetConfig(config)
+	recordAudit(r, "ratelimit.update", "", before, h.Limiter.Config(), nil)
+	writeJson(w, http.StatusOK, jsonRateLimit{Config: h.Limiter.Config(), Stats: h.Limiter.Stats()})
+}
+
+// SplitRoutes serves only the management routes of next when manage is set
+// and only the others when not, for the scan and management apis to listen
+// apart.
+func SplitRoutes(manage bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (routeOf(r) == ROUTE_MANAGE) != manage {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"realtime/apikey"
+	"realtime/ratelimit"
+)
+
+func TestRouteOf(t *testing.T) {
+	tests := []struct {
+		target string
+		route  string
+	}{
+		{"/", ROUTE_MANAGE},
+		{MANAGE_PREFIX + "/tenants", ROUTE_MANAGE},
+		{"/debug/pprof/", ROUTE_MANAGE},
+		{"/twitterstream/1", ROUTE_SCAN},
+		{"/twitterstream/" + NEXT_PATH, ROUTE_NEXT},
+		{"/twitterstream/" + LABELS_PATH, ROUTE_LABELS},
+		{"/webhook/" + INGEST_PATH, ROUTE_INGEST},
+		{"/twitterstream/1/" + ITEMS_PATH, ROUTE_ITEMS},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if route := routeOf(r); route != tt.route {
+			t.Errorf("routeOf(%s) = %q, want %q", tt.target, route, tt.route)
+		}
+	}
+}
+
+func TestRateLimitByKey(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Config{
+		Routes:  map[string]ratelimit.Limit{ROUTE_SCAN: {Rate: 1, Burst: 1}, ROUTE_UNAUTHENTICATED: {Rate: 1, Burst: 2}},
+		Clients: map[string]ratelimit.Limit{"key:k1(scanner)": {Rate: 1, Burst: 3}},
+	})
+	// stands in for RequireApiKeys
+	withKey := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(API_KEY_HEADER) != "" {
+				r = r.WithContext(apikey.NewContext(r.Context(), apikey.Key{Id: "k1", Name: "scanner"}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := LimitAddresses(limiter, withKey(RateLimit(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+
+	// steps run in order, all from one address
+	steps := []struct {
+		key  bool
+		code int
+	}{
+		// the key has its own limit, beyond the one of the address
+		{true, http.StatusOK},
+		{true, http.StatusOK},
+		{true, http.StatusOK},
+		{true, http.StatusTooManyRequests},
+		// the address without a key is limited on the scan route
+		{false, http.StatusOK},
+		{false, http.StatusTooManyRequests},
+		// and before the key check on the unauthenticated route
+		{false, http.StatusTooManyRequests},
+	}
+	for i, step := range steps {
+		r := httptest.NewRequest("PUT", "/twitterstream/1", nil)
+		if step.key {
+			r.Header.Set(API_KEY_HEADER, "rtk_secret")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != step.code {
+			t.Errorf("step %d: code = %d, want %d", i, w.Code, step.code)
+		}
+		if retry_after := w.Header().Get("Retry-After"); w.Code == http.StatusTooManyRequests && retry_after != "1" {
+			t.Errorf("step %d: Retry-After = %q, want 1", i, retry_after)
+		}
+	}
+	if limited := limiter.Stats().Limited; limited != 3 {
+		t.Errorf("Stats().Limited = %d, want 3", limited)
+	}
+}
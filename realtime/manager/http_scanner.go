@@ -3,6 +3,8 @@ package manager
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"engines/github.com.blackjack.syslog"
 
@@ -17,11 +19,15 @@ type responseCodeEnum int
 const (
 	RESPONSE_OK             responseCodeEnum = http.StatusOK
 	RESPONSE_CREATED        responseCodeEnum = http.StatusCreated
+	RESPONSE_ACCEPTED       responseCodeEnum = http.StatusAccepted
 	RESPONSE_BAD_REQUEST    responseCodeEnum = http.StatusBadRequest
 	RESPONSE_NOT_FOUND      responseCodeEnum = http.StatusNotFound
 	RESPONSE_NOT_ALLOWED    responseCodeEnum = http.StatusMethodNotAllowed
 	RESPONSE_UNAUTHORIZED   responseCodeEnum = http.StatusUnauthorized
+	RESPONSE_FORBIDDEN      responseCodeEnum = http.StatusForbidden
+	RESPONSE_RATE_LIMITED   responseCodeEnum = http.StatusTooManyRequests
 	RESPONSE_INTERNAL_ERROR responseCodeEnum = http.StatusInternalServerError
+	RESPONSE_UNAVAILABLE    responseCodeEnum = http.StatusServiceUnavailable
 )
 
 type scanCodeEnum string
@@ -36,10 +42,12 @@ type reasonCodeEnum string
 
 const (
 	REASON_DO_SCAN_NOT_MONITORED      reasonCodeEnum = "not monitored"
+	REASON_DO_SCAN_OVER_CAPACITY      reasonCodeEnum = "over capacity, poll the source"
 	REASON_DO_SCAN_MONITORING_OFF     reasonCodeEnum = "monitoring turned off"
 	REASON_DO_SCAN_FIRST_SCAN         reasonCodeEnum = "first scan since monitor started"
 	REASON_DO_SCAN_NEW_CONTENT        reasonCodeEnum = "new content has arrived"
 	REASON_DO_NOT_SCAN_NO_NEW_CONTENT reasonCodeEnum = "no new content"
+	REASON_INGEST_ACCEPTED            reasonCodeEnum = "event accepted"
 
 	ERROR_JSON_UNPARSABLE                reasonCodeEnum = "cannot parse"
 	ERROR_JSON_INVALID                   reasonCodeEnum = "unexpected json"
@@ -49,6 +57,29 @@ const (
 	ERROR_TRY_ANOTHER_METHOD             reasonCodeEnum = "try another method"
 	ERROR_ACCOUNT_CANNOT_STORE           reasonCodeEnum = "could not store account for monitoring"
 	ERROR_ACCOUNT_CANNOT_UPDATE_LASTSCAN reasonCodeEnum = "unable to update last scan date"
+	ERROR_INGEST_DOWN                    reasonCodeEnum = "ingest down"
+	ERROR_PAYLOAD_UNREADABLE             reasonCodeEnum = "cannot read payload"
+	ERROR_SIGNATURE_MISSING              reasonCodeEnum = "signature missing"
+	ERROR_SIGNATURE_INVALID              reasonCodeEnum = "signature invalid"
+	ERROR_REQUEST_EXPIRED                reasonCodeEnum = "timestamp outside window"
+	ERROR_NONCE_REPLAYED                 reasonCodeEnum = "nonce already used"
+	ERROR_KINDS_INVALID                  reasonCodeEnum = "unknown content kind"
+	ERROR_SINCE_INVALID                  reasonCodeEnum = "since is not a sequence"
+	ERROR_CONSUMER_UNKNOWN               reasonCodeEnum = "consumer is not registered"
+	ERROR_CONSUMER_UNSUPPORTED           reasonCodeEnum = "only the default consumer is supported"
+	ERROR_LIMIT_INVALID                  reasonCodeEnum = "limit must be a positive number"
+	ERROR_VISIBILITY_INVALID             reasonCodeEnum = "visibility must be a duration up to 1h"
+	ERROR_ITEMS_DISABLED                 reasonCodeEnum = "item buffer disabled"
+	ERROR_PRIORITY_INVALID               reasonCodeEnum = "priority must be a number"
+	ERROR_LABELS_INVALID                 reasonCodeEnum = "labels must be key:value, at most 16 per account"
+	ERROR_TENANT_UNKNOWN                 reasonCodeEnum = "unknown tenant key"
+	ERROR_CREDENTIAL_ROTATION_FAILED     reasonCodeEnum = "credential rotation failed"
+	ERROR_CREDENTIAL_REJECTED            reasonCodeEnum = "credential rejected by the source"
+	ERROR_API_KEY_MISSING                reasonCodeEnum = "api key missing"
+	ERROR_API_KEY_INVALID                reasonCodeEnum = "api key invalid or revoked"
+	ERROR_API_KEY_SCOPE                  reasonCodeEnum = "api key lacks the scope"
+	ERROR_RATE_LIMITED                   reasonCodeEnum = "rate limit exceeded, retry after the delay"
+	ERROR_OVERLOADED                     reasonCodeEnum = "server saturated, retry later"
 )
 
 type jsonResponse struct {
@@ -57,41 +88,88 @@ type jsonResponse struct {
 	Reason  string `json:",omitempty"`
 }
 
+// jsonScanResponse carries the per-account details of a scan response, it
+// cannot be cached like jsonResponse.
+type jsonScanResponse struct {
+	jsonResponse
+	Kinds        []string   `json:",omitempty"`
+	Sequence     uint64     `json:",omitempty"`
+	State        string     `json:",omitempty"`
+	StateReason  string     `json:",omitempty"`
+	StateChanged *time.Time `json:",omitempty"`
+}
+
 var jsonResponses = make(map[jsonResponse]*[]byte)
 
 func init() {
 	makeJson(RESPONSE_OK, SCAN_YES, REASON_DO_SCAN_NOT_MONITORED)
+	makeJson(RESPONSE_OK, SCAN_YES, REASON_DO_SCAN_OVER_CAPACITY)
 	makeJson(RESPONSE_OK, SCAN_YES, REASON_DO_SCAN_MONITORING_OFF)
 	makeJson(RESPONSE_OK, SCAN_YES, REASON_DO_SCAN_NEW_CONTENT)
 	makeJson(RESPONSE_OK, SCAN_NO, REASON_DO_NOT_SCAN_NO_NEW_CONTENT)
 
 	makeJson(RESPONSE_CREATED, SCAN_YES, REASON_DO_SCAN_NOT_MONITORED)
+	makeJson(RESPONSE_CREATED, SCAN_YES, REASON_DO_SCAN_OVER_CAPACITY)
 	makeJson(RESPONSE_CREATED, SCAN_YES, REASON_DO_SCAN_MONITORING_OFF)
 	makeJson(RESPONSE_CREATED, SCAN_YES, REASON_DO_SCAN_NEW_CONTENT)
 	makeJson(RESPONSE_CREATED, SCAN_NO, REASON_DO_NOT_SCAN_NO_NEW_CONTENT)
 
 	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_JSON_UNPARSABLE)
 	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_JSON_INVALID)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_KINDS_INVALID)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_SINCE_INVALID)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_CONSUMER_UNKNOWN)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_CONSUMER_UNSUPPORTED)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_LIMIT_INVALID)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_VISIBILITY_INVALID)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_PRIORITY_INVALID)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_LABELS_INVALID)
 
 	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_CREDENTIAL_INVALID)
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_TENANT_UNKNOWN)
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_CREDENTIAL_ROTATION_FAILED)
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_CREDENTIAL_REJECTED)
 
 	makeJson(RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ACCOUNT_NOT_MONITORED)
 	makeJson(RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ROUTE_DOWN)
+	makeJson(RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ITEMS_DISABLED)
 
 	makeJson(RESPONSE_NOT_ALLOWED, SCAN_UNDEFINED, ERROR_TRY_ANOTHER_METHOD)
 
 	makeJson(RESPONSE_INTERNAL_ERROR, SCAN_UNDEFINED, ERROR_ACCOUNT_CANNOT_STORE)
 	makeJson(RESPONSE_INTERNAL_ERROR, SCAN_UNDEFINED, ERROR_ACCOUNT_CANNOT_UPDATE_LASTSCAN)
+
+	makeJson(RESPONSE_ACCEPTED, SCAN_UNDEFINED, REASON_INGEST_ACCEPTED)
+	makeJson(RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_PAYLOAD_UNREADABLE)
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_SIGNATURE_MISSING)
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_SIGNATURE_INVALID)
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_REQUEST_EXPIRED)
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_NONCE_REPLAYED)
+
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_API_KEY_MISSING)
+	makeJson(RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_API_KEY_INVALID)
+	makeJson(RESPONSE_FORBIDDEN, SCAN_UNDEFINED, ERROR_API_KEY_SCOPE)
+
+	makeJson(RESPONSE_RATE_LIMITED, SCAN_UNDEFINED, ERROR_RATE_LIMITED)
+	makeJson(RESPONSE_UNAVAILABLE, SCAN_UNDEFINED, ERROR_OVERLOADED)
+	makeJson(RESPONSE_UNAVAILABLE, SCAN_UNDEFINED, ERROR_INGEST_DOWN)
 }
 
-func scanCodeAndReason(s *state.State, account *account_entry.Entry) (scanCodeEnum, reasonCodeEnum) {
+// scanCodeAndReason decides whether the scanner should scan. With since set
+// the decision is whether anything arrived after that sequence rather than
+// after the account's last scan.
+func scanCodeAndReason(s *state.State, account *account_entry.Entry, consumer uint32, since *uint64) (scanCodeEnum, reasonCodeEnum) {
 	if *s.State() != state.UP {
 		return SCAN_YES, REASON_DO_SCAN_MONITORING_OFF
-	} else if account.State() == account_entry.UNMONITORED {
+	} else if account.State() == account_entry.OVER_CAPACITY {
+		return SCAN_YES, REASON_DO_SCAN_OVER_CAPACITY
+	} else if account.State() != account_entry.MONITORED {
 		return SCAN_YES, REASON_DO_SCAN_NOT_MONITORED
-	} else if account.ScannerSeen() == false {
+	} else if account.ScannerSeenBy(consumer) == false {
 		return SCAN_YES, REASON_DO_SCAN_FIRST_SCAN
-	} else if account.IsUpdated() == true {
+	} else if since != nil && account.UpdatedSince(*since) {
+		return SCAN_YES, REASON_DO_SCAN_NEW_CONTENT
+	} else if since == nil && account.IsUpdatedFor(consumer) == true {
 		return SCAN_YES, REASON_DO_SCAN_NEW_CONTENT
 	} else {
 		return SCAN_NO, REASON_DO_NOT_SCAN_NO_NEW_CONTENT
@@ -104,6 +182,60 @@ func sendResponse(w http.ResponseWriter, r *http.Request, responseCode responseC
 	w.Write(*json_bytes)
 }
 
+func scanResponse(responseCode responseCodeEnum, scanCode scanCodeEnum, reasonCode reasonCodeEnum) jsonScanResponse {
+	return jsonScanResponse{jsonResponse: jsonResponse{Code: responseCode, Message: string(scanCode), Reason: string(reasonCode)}}
+}
+
+// withState tells the scanner which state the account is in and why.
+func (j_response jsonScanResponse) withState(account *account_entry.Entry) jsonScanResponse {
+	account_state, reason, changed := account.StateInfo()
+	j_response.State = account_state.String()
+	j_response.StateReason = reason
+	j_response.StateChanged = &changed
+	return j_response
+}
+
+func sendScanResponse(w http.ResponseWriter, r *http.Request, j_response jsonScanResponse) {
+	json_bytes, err := json.Marshal(j_response)
+	if err != nil {
+		syslog.Alertf("Unable to jsonMarshal(response %d, scan '%s', reason '%s'), error '%s'\n", j_response.Code, j_response.Message, j_response.Reason, err)
+		sendResponse(w, r, RESPONSE_INTERNAL_ERROR, SCAN_UNDEFINED, ERROR_ACCOUNT_CANNOT_STORE)
+		return
+	}
+	w.WriteHeader(int(j_response.Code))
+	w.Write(json_bytes)
+}
+
+// consumerParam resolves the consumer named by the X-Scan-Consumer header or
+// the consumer query parameter, unnamed scanners are the default consumer.
+func consumerParam(r *http.Request, store *account_store.Store) (uint32, bool) {
+	name := r.Header.Get("X-Scan-Consumer")
+	if name == "" {
+		name = r.URL.Query().Get("consumer")
+	}
+	if name == "" {
+		return account_entry.DEFAULT_CONSUMER, true
+	}
+	consumer, present := store.Consumer(name)
+	if !present {
+		return account_entry.DEFAULT_CONSUMER, false
+	}
+	return consumer.Id, true
+}
+
+// sinceParam returns the optional since=<seq> query parameter.
+func sinceParam(r *http.Request) (*uint64, bool) {
+	value := r.URL.Query().Get("since")
+	if value == "" {
+		return nil, true
+	}
+	since, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &since, true
+}
+
 func makeJson(response_code responseCodeEnum, scan_code scanCodeEnum, reason_code reasonCodeEnum) *[]byte {
 	j_response := jsonResponse{Code: response_code, Message: string(scan_code), Reason: string(reason_code)}
 	json_present, present := jsonResponses[j_response]
@@ -122,19 +254,27 @@ func makeJson(response_code responseCodeEnum, scan_code scanCodeEnum, reason_cod
 	return jsonResponses[j_response]
 }
 
-func (b *baseManager) HttpHandler(w http.ResponseWriter, r *http.Request) {
+func (b *BaseRouter) HttpHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	s := b.State()
-	store := b.Store()
-	c := b.Credential()
 
 	if *s.State() != state.UP {
 		sendResponse(w, r, RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ROUTE_DOWN)
 		return
 	}
+	store, c, known := b.tenantOf(r)
+	if !known {
+		sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_TENANT_UNKNOWN)
+		return
+	}
 
 	if r.Method == "PUT" {
-		handlePut(w, r, s, store, c)
+		// a scanner without a tenant key may be found by its credential
+		var tenants *Tenants
+		if r.Header.Get("X-Tenant-Key") == "" {
+			tenants = b.tenants
+		}
+		handlePut(w, r, s, store, c, tenants)
 	} else if r.Method == "GET" {
 		handleGet(w, r, s, store, c)
 	} else if r.Method == "HEAD" {
@@ -146,11 +286,28 @@ func (b *baseManager) HttpHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleGet(w http.ResponseWriter, r *http.Request, s *state.State, store *account_store.Store, c *credential.Credential) {
+	if c.Invalid() {
+		sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_CREDENTIAL_REJECTED)
+		return
+	}
 	account_id := string(r.URL.Query().Get(":id"))
+	since, valid := sinceParam(r)
+	if !valid {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_SINCE_INVALID)
+		return
+	}
+	consumer, known := consumerParam(r, store)
+	if !known {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_CONSUMER_UNKNOWN)
+		return
+	}
 	account, account_present := store.AccountEntry(account_id)
 	if account_present {
-		scanCode, reasonCode := scanCodeAndReason(s, account)
-		sendResponse(w, r, RESPONSE_OK, scanCode, reasonCode)
+		scanCode, reasonCode := scanCodeAndReason(s, account, consumer, since)
+		j_response := scanResponse(RESPONSE_OK, scanCode, reasonCode)
+		j_response.Kinds = account.PendingKindsFor(consumer).Names()
+		j_response.Sequence = account.LastUpdateSeq()
+		sendScanResponse(w, r, j_response.withState(account))
 	} else {
 		sendResponse(w, r, RESPONSE_NOT_FOUND, SCAN_UNDEFINED, ERROR_ACCOUNT_NOT_MONITORED)
 	}
@@ -166,25 +323,86 @@ func handleHead(w http.ResponseWriter, r *http.Request, s *state.State, store *a
 	}
 }
 
-func handlePut(w http.ResponseWriter, r *http.Request, s *state.State, store *account_store.Store, c *credential.Credential) {
-	credential := credential.CredentialFromJson(r.Body)
-	if credential == nil {
-		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_JSON_UNPARSABLE)
+func handlePut(w http.ResponseWriter, r *http.Request, s *state.State, store *account_store.Store, c *credential.Credential, tenants *Tenants) {
+	// a credential loaded from a secret source need not be sent along
+	if !c.Optional() && !(c.Source() != "" && r.ContentLength == 0) {
+		credential := credential.CredentialFromJson(r.Body)
+		if credential == nil {
+			sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_JSON_UNPARSABLE)
+			return
+		}
+		if !c.Accepts(credential) {
+			sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_JSON_INVALID)
+			return
+		}
+		if tenants != nil {
+			if tenant, present := tenants.ByCredential(credential); present {
+				store, c = tenant.Store(), tenant.Credential()
+			}
+		}
+		// scanners update a stale credential, one in use, rejected or loaded
+		// is only replaced with rotate=true or through the management api
+		if c.Source() == "" && c.Stale() == true {
+			before, changed := c.Json().Id(), c.Changed(credential)
+			c.Update(credential)
+			if changed {
+				recordAudit(r, "credential.update", string(store.Property), before, credential.Id(), nil)
+			}
+		} else if c.Changed(credential) {
+			// with api keys rotating needs the credentials:rotate scope,
+			// see scopesOf
+			if r.URL.Query().Get("rotate") != "true" {
+				sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_CREDENTIAL_INVALID)
+				return
+			}
+			before := c.Json().Id()
+			err := c.Rotate(credential, 0)
+			recordAudit(r, "credential.rotate", string(store.Property), before, credential.Id(), err)
+			if err != nil {
+				sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_CREDENTIAL_ROTATION_FAILED)
+				return
+			}
+		}
+	}
+
+	if c.Invalid() {
+		sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_CREDENTIAL_REJECTED)
 		return
 	}
-	if !credential.Valid() {
-		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_JSON_INVALID)
+
+	consumer, known := consumerParam(r, store)
+	if !known {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_CONSUMER_UNKNOWN)
 		return
 	}
-	if c.Stale() == true {
-		c.Update(credential)
-	} else {
-		if c.Changed(credential) {
-			sendResponse(w, r, RESPONSE_UNAUTHORIZED, SCAN_UNDEFINED, ERROR_CREDENTIAL_INVALID)
+
+	kind_policy := account_entry.KIND_NONE
+	if kinds := r.URL.Query().Get("kinds"); kinds != "" {
+		var valid bool
+		kind_policy, valid = account_entry.ParseKinds(kinds)
+		if !valid {
+			sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_KINDS_INVALID)
 			return
 		}
 	}
 
+	priority, priority_set := 0, false
+	if value := r.URL.Query().Get("priority"); value != "" {
+		var err error
+		priority, err = strconv.Atoi(value)
+		if err != nil {
+			sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_PRIORITY_INVALID)
+			return
+		}
+		priority_set = true
+	}
+
+	labels, valid := labelsParam(r)
+	if !valid {
+		sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_LABELS_INVALID)
+		return
+	}
+
 	var account *account_entry.Entry
 	var account_present bool
 
@@ -196,21 +414,47 @@ func handlePut(w http.ResponseWriter, r *http.Request, s *state.State, store *ac
 	account, account_present = store.AccountEntry(account_id)
 	if account_present {
 		responseCode = RESPONSE_OK
+		if store.Wake(account_id) {
+			syslog.Infof("account %s of %s woken from dormancy", account_id, store.Property)
+		}
 	} else {
 		store.AddAccountEntry(account_id)
 		account, account_present = store.AccountEntry(account_id)
 		if account_present {
 			responseCode = RESPONSE_CREATED
+			recordAudit(r, "account.add", string(store.Property)+"/"+account_id, nil, nil, nil)
 		} else {
 			sendResponse(w, r, RESPONSE_INTERNAL_ERROR, SCAN_UNDEFINED, ERROR_ACCOUNT_CANNOT_STORE)
 			return
 		}
 	}
-	scanCode, reasonCode = scanCodeAndReason(s, account)
-	if account.SetLastScan() == false {
-		w.WriteHeader(int(RESPONSE_INTERNAL_ERROR))
-		w.Write(*makeJson(RESPONSE_INTERNAL_ERROR, SCAN_UNDEFINED, ERROR_ACCOUNT_CANNOT_UPDATE_LASTSCAN))
-		return
+	if kind_policy != account_entry.KIND_NONE {
+		account.SetKindPolicy(kind_policy)
+	}
+	if labels != nil {
+		if err := store.SetLabels(account_id, labels); err != nil {
+			// only the labels already on an account can add up to too
+			// many, a new one is refused by labelsParam
+			sendResponse(w, r, RESPONSE_BAD_REQUEST, SCAN_UNDEFINED, ERROR_LABELS_INVALID)
+			return
+		}
+	}
+	if priority_set {
+		store.SetPriority(account_id, priority)
+	}
+	scanCode, reasonCode = scanCodeAndReason(s, account, consumer, nil)
+	sequence, updated, pending := account.ScanAs(consumer)
+	// content that arrived after the decision was consumed by this scan
+	if updated && scanCode == SCAN_NO {
+		scanCode, reasonCode = SCAN_YES, REASON_DO_SCAN_NEW_CONTENT
+	}
+	// clear_items=true drops the buffered items this scan consumed, for
+	// every consumer
+	if r.URL.Query().Get("clear_items") == "true" {
+		store.ClearItems(account_id, sequence)
 	}
-	sendResponse(w, r, responseCode, scanCode, reasonCode)
+	j_response := scanResponse(responseCode, scanCode, reasonCode)
+	j_response.Kinds = pending.Names()
+	j_response.Sequence = sequence
+	sendScanResponse(w, r, j_response.withState(account))
 }
@@ -0,0 +1,65 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"realtime/account_store"
+	"realtime/credential"
+	"realtime/state"
+)
+
+func TestPutAfterRefreshTokenRotation(t *testing.T) {
+	// the token server rotates the refresh token with every grant
+	refresh_tokens := []string{}
+	grants := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		refresh_tokens = append(refresh_tokens, r.PostForm.Get("refresh_token"))
+		grants += 1
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access` + strconv.Itoa(grants) + `","token_type":"bearer","expires_in":1,"refresh_token":"refresh` + strconv.Itoa(grants) + `"}`))
+	}))
+	defer server.Close()
+
+	original := &credential.JsonCredential{OAuth2: &credential.OAuth2Credential{ClientId: "client", ClientSecret: "secret", TokenUrl: server.URL, RefreshToken: "refresh0"}}
+	c := credential.NewCredential()
+	var persisted *credential.JsonCredential
+	c.SetPersist(func(json_credential *credential.JsonCredential) { persisted = json_credential })
+	c.Update(original)
+	if _, err := c.AccessToken(); err != nil {
+		t.Fatalf("AccessToken() = %v", err)
+	}
+	if persisted == nil || persisted.OAuth2.RefreshToken != "refresh1" {
+		t.Errorf("persisted %+v, want the rotated refresh token", persisted)
+	}
+	if c.Changed(original) {
+		t.Errorf("Changed(original) = true after the refresh token was rotated")
+	}
+
+	body, _ := json.Marshal(original)
+	r := httptest.NewRequest("PUT", "/twitterstream/1?:id=1", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	store := account_store.New(false)
+	store.Property = account_store.TWITTER_STREAM
+	handlePut(w, r, state.NewState(), store, c, nil)
+	if w.Code != http.StatusCreated {
+		t.Errorf("PUT with the original credential = %d %s, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+
+	// the next grant is made with the rotated refresh token, not the one
+	// the scanner sent
+	if _, err := c.AccessToken(); err != nil {
+		t.Fatalf("AccessToken() = %v", err)
+	}
+	if len(refresh_tokens) != 2 || refresh_tokens[1] != "refresh1" {
+		t.Errorf("grants made with refresh tokens %v, want [refresh0 refresh1]", refresh_tokens)
+	}
+	if json_credential := c.Json(); json_credential.OAuth2.RefreshToken != "refresh2" {
+		t.Errorf("Json() refresh token = %q, want refresh2", json_credential.OAuth2.RefreshToken)
+	}
+}
@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"net/http"
+	"time"
+
+	"realtime/credential"
+	"realtime/state"
+)
+
+type jsonTenant struct {
+	Id              string
+	Key             string
+	Created         time.Time
+	Accounts        int64
+	CredentialStale bool
+	ConnectorState  state.StateEnum
+}
+
+// tenantJson shows key when given, else the abbreviated key.
+func tenantJson(tenant *Tenant, key string) jsonTenant {
+	if key == "" {
+		key = tenant.KeyPrefix + "..."
+	}
+	return jsonTenant{
+		Id:              tenant.Id,
+		Key:             key,
+		Created:         tenant.Created,
+		Accounts:        tenant.Store().Count(),
+		CredentialStale: tenant.Credential().Stale(),
+		ConnectorState:  *tenant.Connector().State().State(),
+	}
+}
+
+func (h *HttpManagement) tenantsOf(property string) (*Tenants, bool) {
+	for _, manager := range *h.Managed {
+		if m, ok := manager.(tenanted); ok && manager.Name() == property && m.Tenants() != nil {
+			return m.Tenants(), true
+		}
+	}
+	return nil, false
+}
+
+// handleTenants lists the tenants of every property that has them, keys are
+// abbreviated.
+func (h *HttpManagement) handleTenants(w http.ResponseWriter, r *http.Request) {
+	response := make(map[string][]jsonTenant)
+	for _, manager := range *h.Managed {
+		if m, ok := manager.(tenanted); ok && m.Tenants() != nil {
+			list := make([]jsonTenant, 0)
+			for _, tenant := range m.Tenants().List() {
+				list = append(list, tenantJson(tenant, ""))
+			}
+			response[manager.Name()] = list
+		}
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+// handleTenantPut creates a tenant, the body may carry its credential. The
+// response is the only place the full tenant key is shown.
+func (h *HttpManagement) handleTenantPut(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tenants, present := h.tenantsOf(query.Get(":property"))
+	if !present {
+		http.NotFound(w, r)
+		return
+	}
+	var json_credential *credential.JsonCredential
+	if r.ContentLength != 0 {
+		json_credential = credential.CredentialFromJson(r.Body)
+		if json_credential == nil || !json_credential.Valid() {
+			http.Error(w, "credential is not valid", http.StatusBadRequest)
+			return
+		}
+	}
+	tenant, key, err := tenants.Add(query.Get(":id"), json_credential)
+	recordAudit(r, "tenant.add", query.Get(":property")+"@"+query.Get(":id"), nil, nil, err)
+	if err == ErrTenantCredential {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJson(w, http.StatusCreated, tenantJson(tenant, key))
+}
+
+func (h *HttpManagement) handleTenantDelete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tenants, present := h.tenantsOf(query.Get(":property"))
+	if !present || !tenants.Remove(query.Get(":id")) {
+		http.NotFound(w, r)
+		return
+	}
+	recordAudit(r, "tenant.remove", query.Get(":property")+"@"+query.Get(":id"), nil, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"net/http"
+	"sort"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/account_store"
+)
+
+const IMPORT_MAX_BYTES = 256 << 20
+
+func transferFormat(r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return account_store.FORMAT_NDJSON, true
+	}
+	return format, format == account_store.FORMAT_NDJSON || format == account_store.FORMAT_CSV
+}
+
+// handleExport downloads a property's accounts, ?format=ndjson (default) or
+// csv.
+func (h *HttpManagement) handleExport(w http.ResponseWriter, r *http.Request) {
+	property := r.URL.Query().Get(":property")
+	store, present := Stores(*h.Managed)[property]
+	if !present {
+		http.NotFound(w, r)
+		return
+	}
+	format, valid := transferFormat(r)
+	if !valid {
+		http.Error(w, "format must be ndjson or csv", http.StatusBadRequest)
+		return
+	}
+	if format == account_store.FORMAT_CSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+property+"."+format)
+	if err := account_store.WriteRecords(w, format, store.Export()); err != nil {
+		syslog.Errf("export of %s failed: %s", property, err)
+	}
+}
+
+// handleImport loads an export into a property's store, ?mode=merge (default)
+// keeps accounts missing from the file and ?mode=replace removes them.
+func (h *HttpManagement) handleImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	store, present := Stores(*h.Managed)[query.Get(":property")]
+	if !present {
+		http.NotFound(w, r)
+		return
+	}
+	format, valid := transferFormat(r)
+	if !valid {
+		http.Error(w, "format must be ndjson or csv", http.StatusBadRequest)
+		return
+	}
+	mode := query.Get("mode")
+	if mode != "" && mode != "merge" && mode != "replace" {
+		http.Error(w, "mode must be merge or replace", http.StatusBadRequest)
+		return
+	}
+
+	records, line_errors, err := account_store.ReadRecords(http.MaxBytesReader(w, r.Body, IMPORT_MAX_BYTES), format)
+	if err != nil {
+		http.Error(w, "unable to read import: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	report := store.Import(records, mode == "replace")
+	report.Errors = append(line_errors, report.Errors...)
+	recordAudit(r, "accounts.import", query.Get(":property"), nil, map[string]interface{}{
+		"Replace": report.Replace, "Imported": report.Imported, "Created": report.Created, "Removed": report.Removed, "Errors": len(report.Errors),
+	}, nil)
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	writeJson(w, http.StatusOK, report)
+}
@@ -0,0 +1,133 @@
+package manager
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"engines/github.com.bmizerany.pat"
+	"engines/github.com.garyburd.go-oauth/oauth"
+	"realtime/account_entry"
+	"realtime/account_store"
+	"realtime/credential"
+	"realtime/state"
+)
+
+const (
+	INGEST_MAX_SKEW  = 5 * time.Minute
+	INGEST_MAX_BYTES = 1 << 20
+	INGEST_PATH      = "_ingest"
+)
+
+type IngestConfig struct {
+	// AccountIdPath selects the account ids in the payload, e.g.
+	// "events[].account.id". A path element ending in [] iterates an array.
+	AccountIdPath string
+	// HmacSecret verifies the X-Signature: sha256=<hex> header.
+	HmacSecret string
+	// OauthConsumers maps an OAuth 1.0a consumer key to its secret, for the
+	// HMAC-SHA1 and HMAC-SHA256 signature methods.
+	OauthConsumers map[string]string
+	// OauthRsaConsumers maps a consumer key to the public key of its RSA-SHA1
+	// signatures.
+	OauthRsaConsumers map[string]*rsa.PublicKey
+	// MaxSkew bounds how far a request timestamp may be from our clock, nonces
+	// are remembered for twice this window.
+	MaxSkew time.Duration
+	// TrustedProxies are the peers whose X-Forwarded-Proto tells the scheme
+	// clients signed, the header is ignored from anyone else.
+	TrustedProxies []*net.IPNet
+}
+
+type BaseIngest struct {
+	BaseConnector
+	config IngestConfig
+	// oauth also keeps the nonces of HMAC signed requests
+	oauth *oauth.Server
+}
+
+// ParseTrustedProxies parses comma separated addresses and CIDR ranges.
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New(entry + " is not an address")
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (b *BaseIngest) InitBaseIngest(name string, store *account_store.Store, credential *credential.Credential, pat *pat.PatternServeMux, config IngestConfig) {
+	b.InitBaseConnector(name, store, credential)
+	if config.MaxSkew == 0 {
+		config.MaxSkew = INGEST_MAX_SKEW
+	}
+	b.config = config
+	b.oauth = &oauth.Server{
+		LookupConsumer: b.oauthConsumer,
+		Methods:        []oauth.SignatureMethod{oauth.HMACSHA1, oauth.HMACSHA256, oauth.RSASHA1},
+		MaxSkew:        config.MaxSkew,
+	}
+
+	path := "/" + name + "/" + INGEST_PATH
+	pat.Post(path, http.HandlerFunc(b.IngestHandler))
+	b.Logger.Logprefix = fmt.Sprintf("manager %s, type %s", name, b.Type())
+}
+
+func (b *BaseIngest) oauthConsumer(consumer_key string) (*oauth.Consumer, bool) {
+	if secret, present := b.config.OauthConsumers[consumer_key]; present {
+		return &oauth.Consumer{Secret: secret, Methods: []oauth.SignatureMethod{oauth.HMACSHA1, oauth.HMACSHA256}}, true
+	}
+	if public_key, present := b.config.OauthRsaConsumers[consumer_key]; present {
+		return &oauth.Consumer{PublicKey: public_key, Methods: []oauth.SignatureMethod{oauth.RSASHA1}}, true
+	}
+	return nil, false
+}
+
+// Follow has the store mark its accounts monitored while the ingest is up,
+// an ingest follows every account as soon as it is stored.
+func (b *BaseIngest) Follow() {
+	s := b.State()
+	if *s.State() != state.STARTUP {
+		return
+	}
+	b.Store().SetFollowAll(true)
+	s.SetState(state.UP)
+	for *s.State() != state.SHUTDOWN {
+		s.Sleep(1 * time.Second)
+	}
+	b.Store().SetFollowAll(false)
+	b.setStates(account_entry.DEGRADED, "ingest down")
+	s.SetState(state.DOWN)
+}
+
+func (b *BaseIngest) setStates(account_state account_entry.AccountState, reason string) {
+	store := b.Store()
+	for _, account_id := range store.AccountSlice() {
+		account, account_present := store.AccountEntry(account_id)
+		if account_present {
+			account.SetStateReason(account_state, reason)
+		}
+	}
+}
@@ -27,33 +27,73 @@ const (
 	ROUTER    ConnectorEnum = "router"
 )
 
+// Stores returns the store of every property keyed by property name, the
+// connector and router of a property share one store. Tenant stores are
+// keyed "<property>@<tenant>".
+func Stores(managers []Manager) map[string]*account_store.Store {
+	stores := make(map[string]*account_store.Store)
+	for _, manager := range managers {
+		stores[manager.Name()] = manager.Store()
+	}
+	tenantStores(managers, stores)
+	return stores
+}
+
+// StopAll stops the routers first so scanners are told the route is down
+// rather than served from connectors going away, then the tenant connectors
+// and the connectors.
+func StopAll(managers []Manager) {
+	for _, m := range managers {
+		if m.Type() == ROUTER {
+			Stop(m)
+		}
+	}
+	for _, m := range TenantConnectors(managers) {
+		Stop(m)
+	}
+	for _, m := range managers {
+		if m.Type() != ROUTER {
+			Stop(m)
+		}
+	}
+}
+
 func RestartMonitor(managers []Manager) {
 	reloadTimer := time.Tick(15 * time.Second)
 	for {
 		select {
 		case <-reloadTimer:
 			for _, manager := range managers {
-				t := manager.Type()
-				name := manager.Name()
-				if t != CONNECTOR {
-					manager.Log().Debugf("Skipping restart of %s %s\n", t, name)
-					continue
-				}
-				store := manager.Store()
-				s := manager.State()
-				if store.NeedsRestart() && *s.State() == state.UP {
-					manager.Log().Infof("Restarting %s %s\n", t, name)
-					Stop(manager)
-					Start(manager)
-					manager.Store().SetRestart(false)
-				} else {
-					manager.Log().Debugf("No need to restart %s %s\n", t, name)
-				}
+				restartIfNeeded(manager)
+			}
+			for _, manager := range TenantConnectors(managers) {
+				restartIfNeeded(manager)
 			}
 		}
 	}
 }
 
+func restartIfNeeded(manager Manager) {
+	t := manager.Type()
+	name := manager.Name()
+	if t != CONNECTOR {
+		manager.Log().Debugf("Skipping restart of %s %s\n", t, name)
+		return
+	}
+	store := manager.Store()
+	store.Admit()
+	s := manager.State()
+	if store.NeedsRestart() && *s.State() == state.UP {
+		manager.Log().Infof("Restarting %s %s\n", t, name)
+		recordAudit(nil, "connector.reload", name, nil, nil, nil)
+		Stop(manager)
+		Start(manager)
+		manager.Store().SetRestart(false)
+	} else {
+		manager.Log().Debugf("No need to restart %s %s\n", t, name)
+	}
+}
+
 func Start(m Manager) *state.StateEnum {
 	if *m.State().State() != state.DOWN {
 		m.Log().Info("not starting because its not down")
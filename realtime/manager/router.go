@@ -11,7 +11,8 @@ import (
 
 type BaseRouter struct {
 	baseManager
-	pat *pat.PatternServeMux
+	pat     *pat.PatternServeMux
+	tenants *Tenants
 }
 
 func (b *BaseRouter) InitBaseRouter(name string, store *account_store.Store, credential *credential.Credential, pat *pat.PatternServeMux) {
@@ -20,13 +21,48 @@ func (b *BaseRouter) InitBaseRouter(name string, store *account_store.Store, cre
 
 	b.pat = pat
 
+	// registered ahead of :id which would otherwise match it
+	b.pat.Get("/"+name+"/"+NEXT_PATH, http.HandlerFunc(b.NextHandler))
+	label_path := "/" + name + "/" + LABELS_PATH + "/:key"
+	b.pat.Get(label_path, http.HandlerFunc(b.LabelValuesHandler))
+	b.pat.Get(label_path+"/:value", http.HandlerFunc(b.LabelHandler))
+	b.pat.Put(label_path+"/:value", http.HandlerFunc(b.LabelHandler))
+	b.pat.Del(label_path+"/:value", http.HandlerFunc(b.LabelHandler))
 	b.pat.Put(path, http.HandlerFunc(b.HttpHandler))
 
 	b.pat.Get(path, http.HandlerFunc(b.HttpHandler))
+	b.pat.Get(path+"/"+ITEMS_PATH, http.HandlerFunc(b.ItemsHandler))
 	b.Logger.Logprefix = fmt.Sprintf("manager %s, type %s ", name, b.Type())
 
 }
 
+// EnableTenants lets scanners of the property use their own credential and
+// account set.
+func (b *BaseRouter) EnableTenants(tenants *Tenants) {
+	b.tenants = tenants
+}
+
+func (b *BaseRouter) Tenants() *Tenants {
+	return b.tenants
+}
+
+// tenantOf returns the store and credential of the tenant named by the
+// X-Tenant-Key header, or of the default tenant without one.
+func (b *BaseRouter) tenantOf(r *http.Request) (*account_store.Store, *credential.Credential, bool) {
+	key := r.Header.Get("X-Tenant-Key")
+	if key == "" {
+		return b.Store(), b.Credential(), true
+	}
+	if b.tenants == nil {
+		return nil, nil, false
+	}
+	tenant, present := b.tenants.ByKey(key)
+	if !present {
+		return nil, nil, false
+	}
+	return tenant.Store(), tenant.Credential(), true
+}
+
 func (b *BaseRouter) Type() ConnectorEnum {
 	return ROUTER
 }
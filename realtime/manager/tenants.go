@@ -0,0 +1,396 @@
+package manager
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/account_store"
+	"realtime/credential"
+)
+
+// ConnectorFactory builds the connector of a new tenant, it is also where the
+// tenant's store gets the settings of its property.
+type ConnectorFactory func(store *account_store.Store, credential *credential.Credential) Manager
+
+// Tenant is an account set with its own credential and connector within a
+// property. Scanners address it with its key in X-Tenant-Key or by sending
+// its credential. Only the key's prefix and hash are kept, Add hands out the
+// key itself.
+type Tenant struct {
+	Id         string
+	KeyPrefix  string
+	Created    time.Time
+	key_hash   string
+	store      *account_store.Store
+	credential *credential.Credential
+	connector  Manager
+	start_once sync.Once
+}
+
+func (t *Tenant) Store() *account_store.Store {
+	return t.store
+}
+
+func (t *Tenant) Credential() *credential.Credential {
+	return t.credential
+}
+
+func (t *Tenant) Connector() Manager {
+	return t.connector
+}
+
+// start starts the connector once, a tenant removed while starting waits for
+// it in Remove.
+func (t *Tenant) start() {
+	t.start_once.Do(func() {
+		Start(t.connector)
+	})
+}
+
+// savedTenant is a tenant as written to the tenants file, its credential is
+// kept in the keystore.
+type savedTenant struct {
+	Id        string
+	KeyHash   string
+	KeyPrefix string
+	Created   time.Time
+}
+
+// Tenants are the tenants of one property besides its default tenant, which
+// is the store, credential and connector the property was set up with.
+type Tenants struct {
+	name              string
+	restart_on_change bool
+	optional          bool
+	factory           ConnectorFactory
+	by_id             map[string]*Tenant
+	by_key            map[string]*Tenant
+	path              string
+	keystore          *credential.Keystore
+	rwlock            sync.RWMutex
+}
+
+var ErrTenantCredential = errors.New("credential is not valid for the property")
+
+func NewTenants(name string, restart_on_change bool, optional bool, factory ConnectorFactory) *Tenants {
+	t := new(Tenants)
+	t.name = name
+	t.restart_on_change = restart_on_change
+	t.optional = optional
+	t.factory = factory
+	t.by_id = make(map[string]*Tenant)
+	t.by_key = make(map[string]*Tenant)
+	return t
+}
+
+// Open keeps the tenants in the file at path and their credentials in
+// keystore, which may be nil, and recreates the tenants saved there. Their
+// connectors are started by StartTenants once the stores are restored.
+func (t *Tenants) Open(path string, keystore *credential.Keystore) error {
+	var saved []savedTenant
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return errors.New(path + " holds no tenants")
+		}
+	}
+
+	t.rwlock.Lock()
+	defer t.rwlock.Unlock()
+	t.path = path
+	t.keystore = keystore
+	for _, s := range saved {
+		tenant := t.newTenant(s.Id, s.KeyHash, s.KeyPrefix, s.Created)
+		if keystore != nil {
+			if json_credential, present := keystore.Get(t.credentialName(s.Id)); present {
+				tenant.credential.Load(json_credential, credential.SOURCE_KEYSTORE)
+			}
+		}
+		t.persist(tenant)
+		t.by_id[s.Id] = tenant
+		t.by_key[s.KeyHash] = tenant
+	}
+	return nil
+}
+
+// Add creates a tenant and starts its connector, it returns the tenant's key.
+// A nil json_credential leaves the credential to be claimed by the tenant's
+// first PUT.
+func (t *Tenants) Add(id string, json_credential *credential.JsonCredential) (*Tenant, string, error) {
+	if err := account_store.ValidAccountId(id); err != nil {
+		return nil, "", errors.New("tenant id is invalid: " + err.Error())
+	}
+	key, err := newTenantKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	t.rwlock.Lock()
+	if _, present := t.by_id[id]; present {
+		t.rwlock.Unlock()
+		return nil, "", errors.New("tenant " + id + " exists")
+	}
+	tenant := t.newTenant(id, hashTenantKey(key), key[:6], time.Now())
+	if json_credential != nil && !tenant.credential.Accepts(json_credential) {
+		t.rwlock.Unlock()
+		return nil, "", ErrTenantCredential
+	}
+	t.persist(tenant)
+	if json_credential != nil {
+		tenant.credential.Update(json_credential)
+	}
+	t.by_id[id] = tenant
+	t.by_key[tenant.key_hash] = tenant
+	if err := t.save(); err != nil {
+		delete(t.by_id, id)
+		delete(t.by_key, tenant.key_hash)
+		t.forget(id)
+		t.rwlock.Unlock()
+		return nil, "", err
+	}
+	t.rwlock.Unlock()
+
+	go tenant.start()
+	return tenant, key, nil
+}
+
+// Remove stops the tenant's connector and forgets its accounts and
+// credential. A connector still starting up is waited for, Stop does nothing
+// before it is up.
+func (t *Tenants) Remove(id string) bool {
+	t.rwlock.Lock()
+	tenant, present := t.by_id[id]
+	var err error
+	if present {
+		delete(t.by_id, id)
+		delete(t.by_key, tenant.key_hash)
+		err = t.save()
+	}
+	t.rwlock.Unlock()
+
+	if !present {
+		return false
+	}
+	if err != nil {
+		syslog.Errf("unable to save tenants of %s: %s", t.name, err)
+	}
+	// waits for a start in progress, or keeps a tenant never started from
+	// being started
+	tenant.start_once.Do(func() {})
+	Stop(tenant.connector)
+	// its accounts are not restored on the next start either
+	if t.path != "" {
+		state_file := filepath.Join(filepath.Dir(t.path), string(tenant.store.Property)+"."+account_store.FORMAT_NDJSON)
+		if err := os.Remove(state_file); err != nil && !os.IsNotExist(err) {
+			syslog.Errf("unable to remove %s: %s", state_file, err)
+		}
+	}
+
+	t.rwlock.Lock()
+	t.forget(id)
+	t.rwlock.Unlock()
+	return true
+}
+
+// newTenant is called with the lock held.
+func (t *Tenants) newTenant(id string, key_hash string, key_prefix string, created time.Time) *Tenant {
+	tenant := &Tenant{Id: id, KeyPrefix: key_prefix, Created: created, key_hash: key_hash}
+	tenant.store = account_store.New(t.restart_on_change)
+	tenant.store.Property = account_store.Property(t.credentialName(id))
+	tenant.credential = credential.NewCredential()
+	if t.optional {
+		tenant.credential = credential.NewOptionalCredential()
+	}
+	// the connector tells which credentials it signs with
+	tenant.connector = t.factory(tenant.store, tenant.credential)
+	return tenant
+}
+
+func (t *Tenants) credentialName(id string) string {
+	return t.name + "@" + id
+}
+
+// persist writes every change of the tenant's credential to the keystore.
+func (t *Tenants) persist(tenant *Tenant) {
+	if t.keystore == nil {
+		return
+	}
+	keystore := t.keystore
+	name := t.credentialName(tenant.Id)
+	tenant.credential.SetPersist(func(json_credential *credential.JsonCredential) {
+		if err := keystore.Put(name, json_credential); err != nil {
+			syslog.Errf("unable to keep %s credential in the keystore: %s", name, err)
+		}
+	})
+}
+
+// forget is called with the lock held, it drops the credential of a removed
+// tenant from the keystore.
+func (t *Tenants) forget(id string) {
+	if t.keystore == nil {
+		return
+	}
+	if _, present := t.by_id[id]; present {
+		return
+	}
+	if err := t.keystore.Delete(t.credentialName(id)); err != nil {
+		syslog.Errf("unable to drop %s credential from the keystore: %s", t.credentialName(id), err)
+	}
+}
+
+// save is called with the lock held, the file is replaced whole so a crash
+// leaves either the old or the new tenants.
+func (t *Tenants) save() error {
+	if t.path == "" {
+		return nil
+	}
+	saved := make([]savedTenant, 0, len(t.by_id))
+	for _, tenant := range t.by_id {
+		saved = append(saved, savedTenant{Id: tenant.Id, KeyHash: tenant.key_hash, KeyPrefix: tenant.KeyPrefix, Created: tenant.Created})
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Id < saved[j].Id })
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(t.path), filepath.Base(t.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), t.path)
+}
+
+func (t *Tenants) ById(id string) (*Tenant, bool) {
+	t.rwlock.RLock()
+	defer t.rwlock.RUnlock()
+
+	tenant, present := t.by_id[id]
+	return tenant, present
+}
+
+func (t *Tenants) ByKey(key string) (*Tenant, bool) {
+	t.rwlock.RLock()
+	defer t.rwlock.RUnlock()
+
+	tenant, present := t.by_key[hashTenantKey(key)]
+	return tenant, present
+}
+
+// ByCredential finds the tenant whose credential is json_credential, stale
+// or not.
+func (t *Tenants) ByCredential(json_credential *credential.JsonCredential) (*Tenant, bool) {
+	t.rwlock.RLock()
+	defer t.rwlock.RUnlock()
+
+	for _, tenant := range t.by_id {
+		if !tenant.credential.Changed(json_credential) {
+			return tenant, true
+		}
+	}
+	return nil, false
+}
+
+// List returns the tenants sorted by id.
+func (t *Tenants) List() []*Tenant {
+	t.rwlock.RLock()
+	defer t.rwlock.RUnlock()
+
+	list := make([]*Tenant, 0, len(t.by_id))
+	for _, tenant := range t.by_id {
+		list = append(list, tenant)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+func newTenantKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashTenantKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type tenanted interface {
+	Tenants() *Tenants
+}
+
+// TenantConnectors returns the connectors of every tenant of the managers.
+func TenantConnectors(managers []Manager) []Manager {
+	var connectors []Manager
+	for _, manager := range managers {
+		if m, ok := manager.(tenanted); ok && m.Tenants() != nil {
+			for _, tenant := range m.Tenants().List() {
+				connectors = append(connectors, tenant.connector)
+			}
+		}
+	}
+	return connectors
+}
+
+// OpenTenants keeps the tenants of every manager in dir and recreates those
+// saved there, see Tenants.Open.
+func OpenTenants(managers []Manager, dir string, keystore *credential.Keystore) error {
+	for _, manager := range managers {
+		if m, ok := manager.(tenanted); ok && m.Tenants() != nil {
+			if err := m.Tenants().Open(filepath.Join(dir, "tenants-"+manager.Name()+".json"), keystore); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StartTenants starts the connectors of the tenants of every manager.
+func StartTenants(managers []Manager) {
+	for _, manager := range managers {
+		if m, ok := manager.(tenanted); ok && m.Tenants() != nil {
+			for _, tenant := range m.Tenants().List() {
+				go tenant.start()
+			}
+		}
+	}
+}
+
+// tenantStores adds the store of every tenant keyed "<property>@<tenant>".
+func tenantStores(managers []Manager, stores map[string]*account_store.Store) {
+	for _, manager := range managers {
+		if m, ok := manager.(tenanted); ok && m.Tenants() != nil {
+			for _, tenant := range m.Tenants().List() {
+				stores[string(tenant.store.Property)] = tenant.store
+			}
+		}
+	}
+}
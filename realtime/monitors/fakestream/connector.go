@@ -1,6 +1,9 @@
 package fakestream
 
 import (
+	"encoding/json"
+	"errors"
+	"sync"
 	"time"
 
 	"engines/fakestream"
@@ -8,6 +11,7 @@ import (
 	"realtime/account_entry"
 	"realtime/account_store"
 	"realtime/credential"
+	"realtime/deadletter"
 	"realtime/manager"
 	"realtime/state"
 )
@@ -15,11 +19,18 @@ import (
 type Connector struct {
 	manager.BaseConnector
 	stream *fakestream.FakeStream
+	rwlock sync.Mutex
 }
 
+// credential_kinds are the credentials the stream can be signed with.
+var credential_kinds = []credential.AuthKind{credential.AUTH_OAUTH1}
+
 func NewConnector(store *account_store.Store, credential *credential.Credential) *Connector {
 	c := new(Connector)
 	c.InitBaseConnector(NAME, store, credential)
+	credential.SetRotator(c.rotate)
+	credential.SetVerifier(c.verify)
+	credential.SetKinds(credential_kinds...)
 	return c
 }
 
@@ -29,7 +40,7 @@ func (c *Connector) Startup() bool {
 }
 
 func (c *Connector) Shutdown() bool {
-	c.stream.Close()
+	c.current().Close()
 	c.Credential().SetStale()
 	return true
 }
@@ -39,19 +50,15 @@ func (c *Connector) filter() {
 	store := c.Store()
 
 	// If something changes the Credential we do not want to create a race-condition here
-	// so use what the function was instantiated with, to be extra-safe
+	// so each open uses a copy of what the credential holds at that time
 	credential := c.Credential()
-	AppId := credential.AppId()
-	AppSecret := credential.AppSecret()
-	ApiOauthToken := credential.ApiOauthToken()
-	ApiOauthTokenSecret := credential.ApiOauthTokenSecret()
 
 	c.Logger.Debugf("Filter initiated to state %s\n", *c_state.State())
 	if *c_state.State() != state.STARTUP {
 		return
 	}
 
-	c.stream = fakestream.New()
+	c.swap(fakestream.New())
 	slice := store.AccountSlice()
 
 	c_state.SetState(state.UP)
@@ -60,17 +67,43 @@ func (c *Connector) filter() {
 		if *c_state.State() == state.SHUTDOWN {
 			break
 		}
-		if c.stream.Up() == false {
+		stream := c.current()
+		if stream.Up() == false {
 			if len(slice) == 0 {
 				c.Logger.Debug("no need to open connector yet")
 				c_state.Sleep(10 * time.Second)
 			} else {
-				err := c.stream.Open(AppId, AppSecret, ApiOauthToken, ApiOauthTokenSecret, slice)
+				// a credential the source rejected is not retried until it is
+				// replaced or a scheduled check finds it valid again
+				credential.VerifyOnce()
+				if credential.Invalid() {
+					if credential.Revert("credential rejected by the source") {
+						continue
+					}
+					c.Logger.Debug("credential rejected by the source, not opening the stream")
+					for _, account_id := range slice {
+						account, account_present := store.AccountEntry(account_id)
+						if account_present {
+							account.SetStateReason(account_entry.DEGRADED, "credential rejected by the source")
+						}
+					}
+					c.State().Sleep(60 * time.Second)
+					continue
+				}
+				cred := credential.Json()
+				err := stream.Open(cred.AppId, cred.AppSecret, cred.ApiOauthToken, cred.ApiOauthTokenSecret, slice)
 				if err != nil {
+					// only a rejected credential is reverted, see Invalid above
 					c.Logger.Warningf("Attempted to open connection but failed: %s - sleeping for 60 seconds\n", err)
+					for _, account_id := range slice {
+						account, account_present := store.AccountEntry(account_id)
+						if account_present {
+							account.SetStateReason(account_entry.DEGRADED, "stream cannot connect: "+err.Error())
+						}
+					}
 					c_state.Sleep(60 * time.Second)
 				} else {
-					c.Logger.Info("connector opened")
+					c.Logger.Infof("connector opened with credential version %d\n", cred.Version)
 					for _, account_id := range slice {
 						account, account_present := store.AccountEntry(account_id)
 						if account_present {
@@ -81,20 +114,24 @@ func (c *Connector) filter() {
 			}
 			continue
 		}
-		resp, err := c.stream.UnmarshalNext()
+		resp, err := stream.UnmarshalNext()
 		// stream is down to get resp == nil and err == nil
 		if resp == nil && err == nil {
 			continue
 		}
 		if err != nil {
+			// a rotation retired the stream while it was read from
+			if stream != c.current() {
+				continue
+			}
 			c.Logger.Warningf("UnmarshalNext error %s\n", err)
 			for _, account_id := range slice {
 				account, account_present := store.AccountEntry(account_id)
 				if account_present {
-					account.SetState(account_entry.UNMONITORED)
+					account.SetStateReason(account_entry.DEGRADED, "stream error: "+err.Error())
 				}
 			}
-			c.stream.Close()
+			stream.Close()
 			continue
 		}
 		if resp.IdStr != "" {
@@ -102,19 +139,62 @@ func (c *Connector) filter() {
 			c.Logger.Debugf("New content for account %s\n", account_id)
 			account, present := store.AccountEntry(account_id)
 			if present {
-				account.SetLastUpdate()
+				raw, _ := json.Marshal(resp)
+				store.RecordItem(account, account_entry.KIND_POST, raw)
 				continue
 			} else {
-				c.Logger.Warningf("Initializing non-existant store for %s  - this should not happen, content %+v\n", account_id, resp)
-				account = store.AddAccountEntry(account_id)
-				account.SetLastUpdate()
+				raw, _ := json.Marshal(resp)
+				c.Quarantine(deadletter.UNEXPECTED, "content for account "+account_id+" which is not stored", raw)
 				continue
 			}
 		}
-		c.Logger.Debugf("Do not know how to handle incoming content %v", *resp)
+		raw, _ := json.Marshal(resp)
+		c.Quarantine(deadletter.UNROUTABLE, "no account id", raw)
 	}
 	c.Logger.Info("Shutting down filter()")
-	c.stream.Close()
+	c.current().Close()
 	c_state.SetState(state.DOWN)
 	return
 }
+
+func (c *Connector) current() *fakestream.FakeStream {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	return c.stream
+}
+
+func (c *Connector) swap(stream *fakestream.FakeStream) *fakestream.FakeStream {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	previous := c.stream
+	c.stream = stream
+	return previous
+}
+
+// rotate opens a second stream with the next credential and cuts over to it
+// once the source accepted it, the stream it replaces is closed after.
+func (c *Connector) rotate(next *credential.JsonCredential) error {
+	if *c.State().State() != state.UP || !c.current().Up() {
+		// the next open uses the rotated credential
+		return nil
+	}
+	slice := c.Store().AccountSlice()
+	if len(slice) == 0 {
+		return nil
+	}
+	if err := c.verify(next); err != nil {
+		return err
+	}
+	stream := fakestream.New()
+	if err := stream.Open(next.AppId, next.AppSecret, next.ApiOauthToken, next.ApiOauthTokenSecret, slice); err != nil {
+		return errors.New("stream cannot connect: " + err.Error())
+	}
+	c.Logger.Info("rotated stream opened, retiring the previous one")
+	c.swap(stream).Close()
+	return nil
+}
+
+// verify asks the source about a credential, for the credential's health.
+func (c *Connector) verify(cred *credential.JsonCredential) error {
+	return fakestream.VerifyCredentials(cred.AppId, cred.AppSecret, cred.ApiOauthToken, cred.ApiOauthTokenSecret)
+}
@@ -0,0 +1,272 @@
+package feedpoll
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"engines/feedpoll"
+
+	"realtime/account_entry"
+	"realtime/account_store"
+	"realtime/credential"
+	"realtime/manager"
+	"realtime/state"
+)
+
+const (
+	WORKERS          = 8
+	PER_HOST         = 2
+	MIN_INTERVAL     = 1 * time.Minute
+	DEFAULT_INTERVAL = 5 * time.Minute
+	MAX_INTERVAL     = 30 * time.Minute
+	POLL_TIMEOUT     = 30 * time.Second
+)
+
+var errUnmappable = errors.New("account id does not map to a feed url")
+
+type schedule struct {
+	account_id string
+	feed       *feedpoll.Feed
+	interval   time.Duration
+	next_poll  time.Time
+	polling    bool
+}
+
+type hostLimit struct {
+	active        int
+	blocked_until time.Time
+}
+
+type Connector struct {
+	manager.BaseConnector
+	poller       *feedpoll.Poller
+	url_template string
+	schedules    map[string]*schedule
+	hosts        map[string]*hostLimit
+	jobs         chan *schedule
+	workers      sync.WaitGroup
+	rwlock       sync.RWMutex
+}
+
+// NewConnector creates a polling connector. Account ids are turned into feed
+// urls with url_template when it contains a %s verb, otherwise the account id
+// must be the base64 (url alphabet) encoding of the feed url.
+func NewConnector(store *account_store.Store, credential *credential.Credential, url_template string) *Connector {
+	c := new(Connector)
+	c.InitBaseConnector(NAME, store, credential)
+	c.poller = feedpoll.New(POLL_TIMEOUT)
+	c.url_template = url_template
+	return c
+}
+
+func (c *Connector) Startup() bool {
+	go c.poll()
+	return true
+}
+
+func (c *Connector) Shutdown() bool {
+	return true
+}
+
+func (c *Connector) FeedUrl(account_id string) (string, error) {
+	var feed_url string
+	if strings.Contains(c.url_template, "%s") {
+		feed_url = fmt.Sprintf(c.url_template, url.PathEscape(account_id))
+	} else {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(account_id, "="))
+		if err != nil {
+			return "", errUnmappable
+		}
+		feed_url = string(decoded)
+	}
+	u, err := url.Parse(feed_url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errUnmappable
+	}
+	return feed_url, nil
+}
+
+func (c *Connector) poll() {
+	c_state := c.State()
+
+	c.Logger.Debugf("Poll initiated to state %s\n", *c_state.State())
+	if *c_state.State() != state.STARTUP {
+		return
+	}
+
+	c.rwlock.Lock()
+	c.schedules = make(map[string]*schedule)
+	c.hosts = make(map[string]*hostLimit)
+	c.jobs = make(chan *schedule, WORKERS)
+	c.rwlock.Unlock()
+
+	for i := 0; i < WORKERS; i++ {
+		c.workers.Add(1)
+		go c.worker()
+	}
+
+	c_state.SetState(state.UP)
+	c.Logger.Debug("Poll is up")
+	for {
+		if *c_state.State() == state.SHUTDOWN {
+			break
+		}
+		c.dispatch(time.Now())
+		c_state.Sleep(1 * time.Second)
+	}
+	c.Logger.Info("Shutting down poll()")
+	close(c.jobs)
+	c.workers.Wait()
+	c_state.SetState(state.DOWN)
+	return
+}
+
+func (c *Connector) worker() {
+	defer c.workers.Done()
+	for sched := range c.jobs {
+		c.pollFeed(sched)
+	}
+}
+
+// dispatch hands every due feed to the worker pool, honouring the per-host
+// concurrency limit and any Retry-After the host has asked for.
+func (c *Connector) dispatch(now time.Time) {
+	store := c.Store()
+	slice := store.AccountSlice()
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	present := make(map[string]bool, len(slice))
+	for _, account_id := range slice {
+		present[account_id] = true
+		if _, ok := c.schedules[account_id]; ok {
+			continue
+		}
+		sched := &schedule{account_id: account_id, interval: DEFAULT_INTERVAL, next_poll: now}
+		feed_url, err := c.FeedUrl(account_id)
+		if err != nil {
+			sched.next_poll = now.Add(MAX_INTERVAL)
+			if account, account_present := store.AccountEntry(account_id); account_present {
+				account.SetLastError(err.Error())
+			}
+		} else {
+			sched.feed = feedpoll.NewFeed(feed_url)
+		}
+		c.schedules[account_id] = sched
+	}
+	for account_id, sched := range c.schedules {
+		if !present[account_id] && !sched.polling {
+			delete(c.schedules, account_id)
+		}
+	}
+
+	for _, account_id := range slice {
+		sched := c.schedules[account_id]
+		if sched.feed == nil || sched.polling || now.Before(sched.next_poll) {
+			continue
+		}
+		host := c.host(sched.feed.Host())
+		if host.active >= PER_HOST || now.Before(host.blocked_until) {
+			continue
+		}
+		select {
+		case c.jobs <- sched:
+			sched.polling = true
+			host.active += 1
+		default:
+			// every worker is busy, pick up the rest on the next tick
+			return
+		}
+	}
+}
+
+func (c *Connector) host(name string) *hostLimit {
+	host, present := c.hosts[name]
+	if !present {
+		host = new(hostLimit)
+		c.hosts[name] = host
+	}
+	return host
+}
+
+func (c *Connector) pollFeed(sched *schedule) {
+	store := c.Store()
+	result, err := c.poller.Poll(sched.feed)
+	now := time.Now()
+
+	account, account_present := store.AccountEntry(sched.account_id)
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	host := c.host(sched.feed.Host())
+	host.active -= 1
+	sched.polling = false
+
+	if err != nil {
+		c.Logger.Warningf("poll of %s for account %s failed: %s\n", sched.feed.Url, sched.account_id, err)
+		sched.interval = minDuration(sched.interval*2, MAX_INTERVAL)
+		sched.next_poll = now.Add(sched.interval)
+		if status_err, ok := err.(feedpoll.HTTPStatusError); ok && status_err.RetryAfter > 0 {
+			sched.next_poll = now.Add(status_err.RetryAfter)
+			if sched.next_poll.After(host.blocked_until) {
+				host.blocked_until = sched.next_poll
+			}
+		}
+		if account_present {
+			account.SetLastError(err.Error())
+			account.SetStateReason(errorState(err), err.Error())
+		}
+		return
+	}
+
+	if account_present {
+		account.SetLastError("")
+		account.SetState(account_entry.MONITORED)
+	}
+	if len(result.NewItems) > 0 {
+		c.Logger.Debugf("%d new items for account %s\n", len(result.NewItems), sched.account_id)
+		sched.interval = maxDuration(sched.interval/2, MIN_INTERVAL)
+		if account_present {
+			account.SetLastUpdate()
+		}
+	} else {
+		sched.interval = minDuration(sched.interval*3/2, MAX_INTERVAL)
+	}
+	sched.next_poll = now.Add(sched.interval)
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// errorState tells a feed the source refuses from a source that is failing.
+func errorState(err error) account_entry.AccountState {
+	if err == feedpoll.ErrNotFeed {
+		return account_entry.REJECTED
+	}
+	if status_err, ok := err.(feedpoll.HTTPStatusError); ok {
+		switch status_err.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+			return account_entry.REJECTED
+		}
+	}
+	return account_entry.DEGRADED
+}
@@ -0,0 +1,10 @@
+package feedpoll
+
+import (
+	"realtime/account_store"
+)
+
+const (
+	PROPERTY account_store.Property = account_store.FEED_POLL
+	NAME     string                 = string(PROPERTY)
+)
@@ -0,0 +1,453 @@
+package restpoll
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"engines/restpoll"
+
+	"realtime/account_entry"
+	"realtime/account_store"
+	"realtime/credential"
+	"realtime/deadletter"
+	"realtime/jsonpath"
+	"realtime/manager"
+	"realtime/state"
+)
+
+const (
+	WORKERS          = 4
+	MIN_INTERVAL     = 1 * time.Minute
+	DEFAULT_INTERVAL = 5 * time.Minute
+	MAX_INTERVAL     = 1 * time.Hour
+	POLL_TIMEOUT     = 30 * time.Second
+	BUDGET_WINDOW    = 15 * time.Minute
+	// weight of the newest observation in an account's update rate
+	RATE_WEIGHT = 0.3
+)
+
+type Config struct {
+	// UrlTemplate is the endpoint url with {id} in place of the account id,
+	// it must not contain a query string.
+	UrlTemplate string
+	// Params is the query string, values may contain {id}.
+	Params     url.Values
+	SinceParam string
+	// ItemIdPath selects the item ids in the response, see package jsonpath.
+	ItemIdPath string
+	// GlobalBudget caps requests per BudgetWindow across all credentials,
+	// zero leaves only the budgets the source reports.
+	GlobalBudget int
+	BudgetWindow time.Duration
+	MinInterval  time.Duration
+	MaxInterval  time.Duration
+	// VerifyUrl is requested to check the credential with the source, no
+	// check is made when empty.
+	VerifyUrl string
+}
+
+type schedule struct {
+	account_id string
+	interval   time.Duration
+	next_poll  time.Time
+	last_poll  time.Time
+	rate       float64
+	polling    bool
+}
+
+type budget struct {
+	remaining   int
+	reset       time.Time
+	known       bool
+	window_used int
+}
+
+type Connector struct {
+	manager.BaseConnector
+	client      *restpoll.Client
+	config      Config
+	schedules   map[string]*schedule
+	global      budget
+	credentials map[string]*budget
+	jobs        chan *schedule
+	workers     sync.WaitGroup
+	rwlock      sync.RWMutex
+}
+
+func NewConnector(store *account_store.Store, credential *credential.Credential, config Config) *Connector {
+	c := new(Connector)
+	c.InitBaseConnector(NAME, store, credential)
+	if config.SinceParam == "" {
+		config.SinceParam = "since_id"
+	}
+	if config.ItemIdPath == "" {
+		config.ItemIdPath = "[].id_str"
+	}
+	if config.BudgetWindow == 0 {
+		config.BudgetWindow = BUDGET_WINDOW
+	}
+	if config.MinInterval == 0 {
+		config.MinInterval = MIN_INTERVAL
+	}
+	if config.MaxInterval == 0 {
+		config.MaxInterval = MAX_INTERVAL
+	}
+	c.config = config
+	c.client = restpoll.New(POLL_TIMEOUT)
+	if config.VerifyUrl != "" {
+		credential.SetVerifier(c.verify)
+	}
+	return c
+}
+
+func (c *Connector) Startup() bool {
+	go c.poll()
+	return true
+}
+
+func (c *Connector) Shutdown() bool {
+	return true
+}
+
+func (c *Connector) poll() {
+	c_state := c.State()
+
+	c.Logger.Debugf("Poll initiated to state %s\n", *c_state.State())
+	if *c_state.State() != state.STARTUP {
+		return
+	}
+
+	c.rwlock.Lock()
+	c.schedules = make(map[string]*schedule)
+	c.credentials = make(map[string]*budget)
+	c.global = budget{reset: time.Now().Add(c.config.BudgetWindow)}
+	c.jobs = make(chan *schedule, WORKERS)
+	c.rwlock.Unlock()
+
+	for i := 0; i < WORKERS; i++ {
+		c.workers.Add(1)
+		go c.worker()
+	}
+
+	c_state.SetState(state.UP)
+	c.Logger.Debug("Poll is up")
+	for {
+		if *c_state.State() == state.SHUTDOWN {
+			break
+		}
+		c.dispatch(time.Now())
+		c_state.Sleep(1 * time.Second)
+	}
+	c.Logger.Info("Shutting down poll()")
+	close(c.jobs)
+	c.workers.Wait()
+	c_state.SetState(state.DOWN)
+	return
+}
+
+func (c *Connector) worker() {
+	defer c.workers.Done()
+	for sched := range c.jobs {
+		c.pollAccount(sched)
+	}
+}
+
+func (c *Connector) auth() (restpoll.Auth, string, error) {
+	return c.authOf(c.Credential().Json())
+}
+
+// authOf returns how to sign requests made with cred and the key of the
+// budget they count against.
+func (c *Connector) authOf(cred *credential.JsonCredential) (restpoll.Auth, string, error) {
+	if cred.Kind() == credential.AUTH_OAUTH2 {
+		// the credential caches the tokens granted to the client, the
+		// budget stays with the client across them. Any other client, e.g.
+		// one being verified, is granted a token of its own.
+		var token string
+		var err error
+		if c.Credential().Changed(cred) {
+			token, err = cred.OAuth2.AccessToken()
+		} else {
+			token, err = c.Credential().AccessToken()
+		}
+		return restpoll.Auth{Bearer: token}, "oauth2:" + cred.OAuth2.ClientId, err
+	}
+	auth := restpoll.Auth{
+		ConsumerKey:    cred.AppId,
+		ConsumerSecret: cred.AppSecret,
+		Token:          cred.ApiOauthToken,
+		TokenSecret:    cred.ApiOauthTokenSecret,
+		Bearer:         cred.BearerToken,
+	}
+	if auth.Bearer != "" {
+		return auth, "bearer:" + auth.Bearer, nil
+	}
+	return auth, auth.ConsumerKey + ":" + auth.Token, nil
+}
+
+// verify requests the verify url with a credential, for the credential's
+// health.
+func (c *Connector) verify(cred *credential.JsonCredential) error {
+	auth, _, err := c.authOf(cred)
+	if err != nil {
+		return err
+	}
+	_, _, err = c.client.Get(auth, c.config.VerifyUrl, nil)
+	if status_err, ok := err.(restpoll.HTTPStatusError); ok {
+		if status_err.StatusCode == http.StatusUnauthorized || status_err.StatusCode == http.StatusForbidden {
+			return credential.InvalidError{Reason: status_err.Error()}
+		}
+	}
+	return err
+}
+
+// dispatch hands due accounts to the workers while both the global budget and
+// the budget of the current credential allow another request.
+func (c *Connector) dispatch(now time.Time) {
+	store := c.Store()
+	cred := c.Credential()
+	if cred.Stale() {
+		return
+	}
+	// no requests are made with a credential the source rejected
+	cred.VerifyOnce()
+	if cred.Invalid() {
+		return
+	}
+	_, credential_key, err := c.auth()
+	if err != nil {
+		c.Logger.Debugf("no token to poll with: %s\n", err)
+		return
+	}
+	slice := store.AccountSlice()
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	present := make(map[string]bool, len(slice))
+	for _, account_id := range slice {
+		present[account_id] = true
+		if _, ok := c.schedules[account_id]; !ok {
+			c.schedules[account_id] = &schedule{account_id: account_id, interval: DEFAULT_INTERVAL, next_poll: now}
+		}
+	}
+	for account_id, sched := range c.schedules {
+		if !present[account_id] && !sched.polling {
+			delete(c.schedules, account_id)
+		}
+	}
+
+	cred_budget := c.credentialBudget(credential_key)
+	if !now.Before(c.global.reset) {
+		c.global.window_used = 0
+		c.global.reset = now.Add(c.config.BudgetWindow)
+	}
+
+	for _, account_id := range slice {
+		sched := c.schedules[account_id]
+		if sched.polling || now.Before(sched.next_poll) {
+			continue
+		}
+		if c.config.GlobalBudget > 0 && c.global.window_used >= c.config.GlobalBudget {
+			return
+		}
+		if cred_budget.known && cred_budget.remaining <= 0 && now.Before(cred_budget.reset) {
+			return
+		}
+		select {
+		case c.jobs <- sched:
+			sched.polling = true
+			c.global.window_used += 1
+			cred_budget.remaining -= 1
+		default:
+			return
+		}
+	}
+}
+
+func (c *Connector) credentialBudget(credential_key string) *budget {
+	b, present := c.credentials[credential_key]
+	if !present {
+		b = new(budget)
+		c.credentials[credential_key] = b
+	}
+	return b
+}
+
+func (c *Connector) pollAccount(sched *schedule) {
+	store := c.Store()
+	cred := c.Credential()
+	used := cred.Json()
+	auth, credential_key, auth_err := c.auth()
+
+	account, account_present := store.AccountEntry(sched.account_id)
+	cursor := ""
+	if account_present {
+		cursor = account.Cursor()
+	}
+
+	escaped_id := url.PathEscape(sched.account_id)
+	url_str := strings.Replace(c.config.UrlTemplate, "{id}", escaped_id, -1)
+	params := make(url.Values)
+	for k, vs := range c.config.Params {
+		for _, v := range vs {
+			params.Add(k, strings.Replace(v, "{id}", sched.account_id, -1))
+		}
+	}
+	if cursor != "" {
+		params.Set(c.config.SinceParam, cursor)
+	}
+
+	var body []byte
+	var rate_limit restpoll.RateLimit
+	err := auth_err
+	if err == nil {
+		body, rate_limit, err = c.client.Get(auth, url_str, params)
+	}
+	now := time.Now()
+
+	var item_ids []string
+	if err == nil {
+		item_ids, err = jsonpath.Collect(body, c.config.ItemIdPath)
+		if err != nil {
+			c.Quarantine(deadletter.UNPARSABLE, "timeline for account "+sched.account_id+": "+err.Error(), body)
+		}
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	sched.polling = false
+	cred_budget := c.credentialBudget(credential_key)
+	if rate_limit.Present {
+		cred_budget.known = true
+		cred_budget.remaining = rate_limit.Remaining
+		cred_budget.reset = rate_limit.Reset
+	}
+
+	if err != nil {
+		c.Logger.Warningf("poll for account %s failed: %s\n", sched.account_id, err)
+		sched.interval = minDuration(sched.interval*2, c.config.MaxInterval)
+		sched.next_poll = now.Add(sched.interval)
+		if status_err, ok := err.(restpoll.HTTPStatusError); ok && status_err.RetryAfter > 0 {
+			sched.next_poll = now.Add(status_err.RetryAfter)
+			cred_budget.known = true
+			cred_budget.remaining = 0
+			cred_budget.reset = sched.next_poll
+		}
+		// the source refused the credential itself, dispatch stops polling
+		// with it until it is replaced, unless it already was
+		if status_err, ok := err.(restpoll.HTTPStatusError); ok && status_err.StatusCode == http.StatusUnauthorized && !cred.Changed(used) {
+			cred.Reject(err.Error())
+		}
+		if account_present {
+			account.SetLastError(err.Error())
+			account.SetStateReason(errorState(err), err.Error())
+		}
+		return
+	}
+
+	new_items := 0
+	newest := cursor
+	for _, item_id := range item_ids {
+		if cursor == "" || compareIds(item_id, cursor) > 0 {
+			new_items += 1
+		}
+		if newest == "" || compareIds(item_id, newest) > 0 {
+			newest = item_id
+		}
+	}
+
+	if account_present {
+		account.SetLastError("")
+		account.SetState(account_entry.MONITORED)
+		if newest != cursor {
+			account.SetCursor(newest)
+		}
+		// the first poll only establishes the cursor
+		if cursor != "" && new_items > 0 {
+			c.Logger.Debugf("%d new items for account %s\n", new_items, sched.account_id)
+			account.SetLastUpdate()
+		}
+	}
+
+	c.adapt(sched, new_items, cursor == "", now)
+}
+
+// adapt keeps an exponentially weighted estimate of items per hour and polls
+// roughly as often as one new item is expected.
+func (c *Connector) adapt(sched *schedule, new_items int, first bool, now time.Time) {
+	if !first && !sched.last_poll.IsZero() {
+		elapsed := now.Sub(sched.last_poll).Hours()
+		if elapsed > 0 {
+			sched.rate = (1-RATE_WEIGHT)*sched.rate + RATE_WEIGHT*float64(new_items)/elapsed
+		}
+	}
+	sched.last_poll = now
+
+	if sched.rate > 0 {
+		sched.interval = time.Duration(float64(time.Hour) / sched.rate)
+	} else {
+		sched.interval = sched.interval * 3 / 2
+	}
+	sched.interval = maxDuration(minDuration(sched.interval, c.config.MaxInterval), c.config.MinInterval)
+	sched.next_poll = now.Add(sched.interval)
+}
+
+// errorState tells an account the source refuses from a source that is
+// failing. An unauthorized credential fails every account so it is not held
+// against the account.
+func errorState(err error) account_entry.AccountState {
+	if status_err, ok := err.(restpoll.HTTPStatusError); ok {
+		switch status_err.StatusCode {
+		case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+			return account_entry.REJECTED
+		}
+	}
+	return account_entry.DEGRADED
+}
+
+// compareIds orders numeric ids numerically without overflowing and any
+// other ids lexically.
+func compareIds(a, b string) int {
+	if isNumeric(a) && isNumeric(b) {
+		a = strings.TrimLeft(a, "0")
+		b = strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,10 @@
+package restpoll
+
+import (
+	"realtime/account_store"
+)
+
+const (
+	PROPERTY account_store.Property = account_store.REST_POLL
+	NAME     string                 = string(PROPERTY)
+)
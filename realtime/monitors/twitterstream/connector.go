@@ -1,7 +1,10 @@
 package twitterstream
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
 	"time"
 
 	"engines/twitterstream"
@@ -9,6 +12,7 @@ import (
 	"realtime/account_entry"
 	"realtime/account_store"
 	"realtime/credential"
+	"realtime/deadletter"
 	"realtime/manager"
 	"realtime/state"
 )
@@ -16,11 +20,18 @@ import (
 type Connector struct {
 	manager.BaseConnector
 	stream *twitterstream.TwitterStream
+	rwlock sync.Mutex
 }
 
+// credential_kinds are the credentials the stream can be signed with.
+var credential_kinds = []credential.AuthKind{credential.AUTH_OAUTH1}
+
 func NewConnector(store *account_store.Store, credential *credential.Credential) *Connector {
 	c := new(Connector)
 	c.InitBaseConnector(NAME, store, credential)
+	credential.SetRotator(c.rotate)
+	credential.SetVerifier(c.verify)
+	credential.SetKinds(credential_kinds...)
 	return c
 }
 
@@ -30,7 +41,7 @@ func (c *Connector) Startup() bool {
 }
 
 func (c *Connector) Shutdown() bool {
-	c.stream.Close()
+	c.current().Close()
 	c.Credential().SetStale()
 	return true
 }
@@ -39,19 +50,15 @@ func (c *Connector) filter() {
 	store := c.Store()
 
 	// If something changes the Credential we do not want to create a race-condition here
-	// so use what the function was instantiated with, to be extra-safe
+	// so each open uses a copy of what the credential holds at that time
 	credential := c.Credential()
-	AppId := credential.AppId()
-	AppSecret := credential.AppSecret()
-	ApiOauthToken := credential.ApiOauthToken()
-	ApiOauthTokenSecret := credential.ApiOauthTokenSecret()
 
 	c.Logger.Debugf("Filter initiated to state %s\n", c.State().State())
 	if *c.State().State() != state.STARTUP {
 		return
 	}
 
-	c.stream = twitterstream.New()
+	c.swap(twitterstream.New())
 	slice := store.AccountSlice()
 
 	c.State().SetState(state.UP)
@@ -60,17 +67,47 @@ func (c *Connector) filter() {
 		if *c.State().State() == state.SHUTDOWN {
 			break
 		}
-		if c.stream.Up() == false {
+		stream := c.current()
+		if stream.Up() == false {
 			if len(slice) == 0 {
 				c.Logger.Debug("no need to open connector yet")
 				c.State().Sleep(10 * time.Second)
 			} else {
-				err := c.stream.Open(AppId, AppSecret, ApiOauthToken, ApiOauthTokenSecret, slice)
+				// a credential the source rejected is not retried until it is
+				// replaced or a scheduled check finds it valid again
+				credential.VerifyOnce()
+				if credential.Invalid() {
+					if credential.Revert("credential rejected by the source") {
+						continue
+					}
+					c.Logger.Debug("credential rejected by the source, not opening the stream")
+					for _, account_id := range slice {
+						account, account_present := store.AccountEntry(account_id)
+						if account_present {
+							account.SetStateReason(account_entry.DEGRADED, "credential rejected by the source")
+						}
+					}
+					c.State().Sleep(60 * time.Second)
+					continue
+				}
+				cred := credential.Json()
+				err := stream.Open(cred.AppId, cred.AppSecret, cred.ApiOauthToken, cred.ApiOauthTokenSecret, slice)
+				if status_err, ok := err.(twitterstream.HTTPStatusError); ok && status_err.StatusCode == http.StatusUnauthorized {
+					credential.Reject(err.Error())
+					continue
+				}
 				if err != nil {
+					// only a rejected credential is reverted, see Invalid above
 					c.Logger.Warningf("Attempted to open connection but failed: %s - sleeping for 60 seconds\n", err)
+					for _, account_id := range slice {
+						account, account_present := store.AccountEntry(account_id)
+						if account_present {
+							account.SetStateReason(account_entry.DEGRADED, "stream cannot connect: "+err.Error())
+						}
+					}
 					c.State().Sleep(60 * time.Second)
 				} else {
-					c.Logger.Info("connector opened")
+					c.Logger.Infof("connector opened with credential version %d\n", cred.Version)
 					for _, account_id := range slice {
 						account, account_present := store.AccountEntry(account_id)
 						if account_present {
@@ -81,58 +118,147 @@ func (c *Connector) filter() {
 			}
 			continue
 		}
-		resp, err := c.stream.UnmarshalNext()
+		resp, err := stream.UnmarshalNext()
 		// stream is down to get resp == nil and err == nil
 		if resp == nil && err == nil {
 			continue
 		}
+		if unmarshal_err, ok := err.(twitterstream.UnmarshalError); ok {
+			c.Quarantine(deadletter.UNPARSABLE, unmarshal_err.Err.Error(), unmarshal_err.Rawsource)
+			continue
+		}
 		if err != nil {
+			// a rotation retired the stream while it was read from
+			if stream != c.current() {
+				continue
+			}
 			c.Logger.Warningf("UnmarshalNext error %s\n", err)
 			for _, account_id := range slice {
 				account, account_present := store.AccountEntry(account_id)
 				if account_present {
-					account.SetState(account_entry.UNMONITORED)
+					account.SetStateReason(account_entry.DEGRADED, "stream error: "+err.Error())
 				}
 			}
-			c.stream.Close()
+			stream.Close()
 			continue
 		}
-		if resp.ScanUserIdStr != "" {
-			account_id := resp.ScanUserIdStr
-			c.Logger.Debugf("New content for account %s\n", account_id)
-			account, present := store.AccountEntry(account_id)
-			if present {
-				account.SetLastUpdate()
-				continue
-			}
-			if resp.RetweetUserIdStr != "" {
-				retweet_account_id := resp.RetweetUserIdStr
-				_, retweet_present := store.AccountEntry(retweet_account_id)
-				if retweet_present {
-					c.Logger.Debugf("Skipping %s because it is a retweet of a monitored account %s\n", account_id, retweet_account_id)
-				} else {
-					create := false
-					for _, user_mention := range resp.UserMentions {
-						if user_mention.IdStr == account_id {
-							create = true
-							c.Logger.Debugf("Skipping %s because it was found in user mentions for account %s\n", account_id, retweet_account_id)
-							break
-						}
-					}
-					if create {
-						c.Logger.Warningf("Initializing non-existant store for %s  - this should not happen, content %+v\n", account_id, resp)
-						log.Fatalf("WTF: %s\n", resp.Rawsource)
-						account = store.AddAccountEntry(account_id)
-						account.SetLastUpdate()
-					}
-				}
-				continue
+		// notices about the stream carry no content to route
+		if notice, is_notice := controlMessage(resp.Rawsource); is_notice {
+			if notice == "disconnect" || notice == "warning" {
+				c.Logger.Warningf("stream %s notice: %s\n", notice, resp.Rawsource)
 			}
+			continue
+		}
+		if !c.route(resp) {
+			c.Quarantine(deadletter.UNROUTABLE, "no monitored account concerned", resp.Rawsource)
 		}
-		c.Logger.Debugf("Do not know how to handle incoming content %v", *resp)
 	}
 	c.Logger.Info("Shutting down filter()")
-	c.stream.Close()
+	c.current().Close()
 	c.State().SetState(state.DOWN)
 	return
 }
+
+func (c *Connector) current() *twitterstream.TwitterStream {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	return c.stream
+}
+
+func (c *Connector) swap(stream *twitterstream.TwitterStream) *twitterstream.TwitterStream {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+	previous := c.stream
+	c.stream = stream
+	return previous
+}
+
+// rotate opens a second stream with the next credential and cuts over to it
+// once the source accepted it, the stream it replaces is closed after.
+func (c *Connector) rotate(next *credential.JsonCredential) error {
+	if *c.State().State() != state.UP || !c.current().Up() {
+		// the next open uses the rotated credential
+		return nil
+	}
+	slice := c.Store().AccountSlice()
+	if len(slice) == 0 {
+		return nil
+	}
+	if err := c.verify(next); err != nil {
+		return err
+	}
+	stream := twitterstream.New()
+	if err := stream.Open(next.AppId, next.AppSecret, next.ApiOauthToken, next.ApiOauthTokenSecret, slice); err != nil {
+		return errors.New("stream cannot connect: " + err.Error())
+	}
+	c.Logger.Info("rotated stream opened, retiring the previous one")
+	c.swap(stream).Close()
+	return nil
+}
+
+// route records the tweet against every monitored account it concerns, with
+// the kind of content it is for that account. It returns false when no
+// monitored account is concerned.
+func (c *Connector) route(resp *twitterstream.TweetResponse) bool {
+	store := c.Store()
+	kinds := make(map[string]account_entry.UpdateKind)
+	add := func(account_id string, kind account_entry.UpdateKind) {
+		if account_id == "" {
+			return
+		}
+		if _, present := store.AccountEntry(account_id); present {
+			kinds[account_id] |= kind
+		}
+	}
+
+	add(resp.AuthorUserIdStr, account_entry.KIND_POST)
+	add(resp.ReplyUserIdStr, account_entry.KIND_REPLY)
+	add(resp.RetweetUserIdStr, account_entry.KIND_RETWEET)
+	add(resp.QuotedUserIdStr, account_entry.KIND_QUOTE)
+	for _, user_mention := range resp.UserMentions {
+		if _, already := kinds[user_mention.IdStr]; already {
+			continue
+		}
+		add(user_mention.IdStr, account_entry.KIND_MENTION)
+	}
+
+	for account_id, kind := range kinds {
+		account, present := store.AccountEntry(account_id)
+		if !present {
+			continue
+		}
+		c.Logger.Debugf("New %v content for account %s\n", kind.Names(), account_id)
+		store.RecordItem(account, kind, resp.Rawsource)
+	}
+	return len(kinds) > 0
+}
+
+// control_messages are the notices the stream sends among the tweets, each
+// an object with a single member named after it.
+var control_messages = map[string]bool{
+	"delete": true, "scrub_geo": true, "limit": true, "status_withheld": true,
+	"user_withheld": true, "disconnect": true, "warning": true,
+}
+
+// controlMessage returns the name of the notice raw is, if it is one.
+func controlMessage(raw []byte) (string, bool) {
+	var members map[string]json.RawMessage
+	if json.Unmarshal(raw, &members) != nil || len(members) != 1 {
+		return "", false
+	}
+	for name := range members {
+		return name, control_messages[name]
+	}
+	return "", false
+}
+
+// verify asks the source about a credential, for the credential's health.
+func (c *Connector) verify(cred *credential.JsonCredential) error {
+	err := twitterstream.VerifyCredentials(cred.AppId, cred.AppSecret, cred.ApiOauthToken, cred.ApiOauthTokenSecret)
+	if status_err, ok := err.(twitterstream.HTTPStatusError); ok {
+		if status_err.StatusCode == http.StatusUnauthorized || status_err.StatusCode == http.StatusForbidden {
+			return credential.InvalidError{Reason: status_err.Error()}
+		}
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package webhook
+
+import (
+	"engines/github.com.bmizerany.pat"
+
+	"realtime/account_store"
+	"realtime/credential"
+	"realtime/manager"
+)
+
+type Connector struct {
+	manager.BaseIngest
+}
+
+func NewConnector(store *account_store.Store, credential *credential.Credential, pat *pat.PatternServeMux, config manager.IngestConfig) *Connector {
+	c := new(Connector)
+	c.InitBaseIngest(NAME, store, credential, pat, config)
+	return c
+}
+
+func (c *Connector) Startup() bool {
+	go c.Follow()
+	return true
+}
+
+func (c *Connector) Shutdown() bool {
+	return true
+}
@@ -0,0 +1,10 @@
+package webhook
+
+import (
+	"realtime/account_store"
+)
+
+const (
+	PROPERTY account_store.Property = account_store.WEBHOOK_INGEST
+	NAME     string                 = string(PROPERTY)
+)
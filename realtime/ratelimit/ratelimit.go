@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ANY_ROUTE is the limit of routes without one of their own.
+	ANY_ROUTE = "*"
+	// IDLE_BUCKETS are forgotten once full and unused for this long.
+	IDLE_BUCKETS = 10 * time.Minute
+)
+
+// Limit is a token bucket refilled with Rate tokens a second up to Burst, a
+// zero Rate does not limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (limit Limit) burst() float64 {
+	if limit.Burst < 1 {
+		return math.Max(1, math.Ceil(limit.Rate))
+	}
+	return float64(limit.Burst)
+}
+
+// Config limits each client on each route, a client found in Clients has its
+// limit on every route instead. MaxConcurrent bounds the requests in flight
+// across all clients, 0 for no bound.
+type Config struct {
+	Routes        map[string]Limit
+	Clients       map[string]Limit
+	MaxConcurrent int
+}
+
+// ParseRoutes parses "<route>=<rate>[/<burst>]" limits separated by commas,
+// e.g. "scan=20/40,next=2,*=50".
+func ParseRoutes(value string) (map[string]Limit, error) {
+	routes := make(map[string]Limit)
+	for _, entry := range strings.Split(value, ",") {
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		if i <= 0 {
+			return nil, errors.New("rate limits must be route=rate[/burst]")
+		}
+		var limit Limit
+		var err error
+		values := strings.SplitN(entry[i+1:], "/", 2)
+		if limit.Rate, err = strconv.ParseFloat(values[0], 64); err != nil || limit.Rate < 0 {
+			return nil, errors.New("rate of " + entry[:i] + " must be a positive number")
+		}
+		if len(values) == 2 {
+			if limit.Burst, err = strconv.Atoi(values[1]); err != nil || limit.Burst < 0 {
+				return nil, errors.New("burst of " + entry[:i] + " must be a positive number")
+			}
+		}
+		routes[entry[:i]] = limit
+	}
+	return routes, nil
+}
+
+// Valid rejects negative limits.
+func (config Config) Valid() error {
+	if config.MaxConcurrent < 0 {
+		return errors.New("MaxConcurrent must not be negative")
+	}
+	for _, limits := range []map[string]Limit{config.Routes, config.Clients} {
+		for name, limit := range limits {
+			if limit.Rate < 0 || limit.Burst < 0 {
+				return errors.New("limit of " + name + " must not be negative")
+			}
+		}
+	}
+	return nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Stats struct {
+	Limited  int64
+	Shed     int64
+	InFlight int64
+	Buckets  int
+}
+
+// Limiter holds a bucket per client and route.
+type Limiter struct {
+	rwlock    sync.RWMutex
+	config    Config
+	buckets   map[string]*bucket
+	pruned    time.Time
+	in_flight int64
+	limited   int64
+	shed      int64
+}
+
+func New(config Config) *Limiter {
+	l := &Limiter{buckets: make(map[string]*bucket)}
+	l.SetConfig(config)
+	return l
+}
+
+// SetConfig takes effect on the next request, buckets keep their tokens up
+// to the new burst.
+func (l *Limiter) SetConfig(config Config) {
+	copied := Config{Routes: make(map[string]Limit), Clients: make(map[string]Limit), MaxConcurrent: config.MaxConcurrent}
+	for route, limit := range config.Routes {
+		copied.Routes[route] = limit
+	}
+	for client, limit := range config.Clients {
+		copied.Clients[client] = limit
+	}
+	l.rwlock.Lock()
+	defer l.rwlock.Unlock()
+	l.config = copied
+}
+
+func (l *Limiter) Config() Config {
+	l.rwlock.RLock()
+	defer l.rwlock.RUnlock()
+	return l.config
+}
+
+// limitOf is called with the lock held.
+func (l *Limiter) limitOf(client string, route string) Limit {
+	if limit, present := l.config.Clients[client]; present {
+		return limit
+	}
+	if limit, present := l.config.Routes[route]; present {
+		return limit
+	}
+	return l.config.Routes[ANY_ROUTE]
+}
+
+// Allow takes a token from the bucket of the client on the route. When there
+// is none it returns how long until there is.
+func (l *Limiter) Allow(client string, route string, now time.Time) (bool, time.Duration) {
+	l.rwlock.Lock()
+	defer l.rwlock.Unlock()
+
+	limit := l.limitOf(client, route)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	if now.Sub(l.pruned) > IDLE_BUCKETS {
+		l.prune(now)
+	}
+	key := client + " " + route
+	b, present := l.buckets[key]
+	if !present {
+		b = &bucket{tokens: limit.burst(), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+	l.limited += 1
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// prune is called with the lock held, a bucket idle this long has refilled
+// and is the same as a new one.
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > IDLE_BUCKETS {
+			delete(l.buckets, key)
+		}
+	}
+	l.pruned = now
+}
+
+// Acquire admits a request unless MaxConcurrent are in flight, an admitted
+// request is let go with Release.
+func (l *Limiter) Acquire() bool {
+	l.rwlock.RLock()
+	max := int64(l.config.MaxConcurrent)
+	l.rwlock.RUnlock()
+	n := atomic.AddInt64(&l.in_flight, 1)
+	if max > 0 && n > max {
+		atomic.AddInt64(&l.in_flight, -1)
+		atomic.AddInt64(&l.shed, 1)
+		return false
+	}
+	return true
+}
+
+func (l *Limiter) Release() {
+	atomic.AddInt64(&l.in_flight, -1)
+}
+
+func (l *Limiter) Stats() Stats {
+	l.rwlock.RLock()
+	defer l.rwlock.RUnlock()
+	return Stats{Limited: l.limited, Shed: atomic.LoadInt64(&l.shed), InFlight: atomic.LoadInt64(&l.in_flight), Buckets: len(l.buckets)}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l := New(Config{
+		Routes:  map[string]Limit{"scan": {Rate: 2, Burst: 3}, ANY_ROUTE: {Rate: 1}},
+		Clients: map[string]Limit{"key:k1": {Rate: 10, Burst: 5}},
+	})
+	start := time.Unix(1500000000, 0)
+
+	// steps run in order against one limiter at start plus after
+	steps := []struct {
+		after   time.Duration
+		client  string
+		route   string
+		allowed bool
+		wait    time.Duration
+	}{
+		// a new bucket is full up to the burst
+		{0, "addr:a", "scan", true, 0},
+		{0, "addr:a", "scan", true, 0},
+		{0, "addr:a", "scan", true, 0},
+		{0, "addr:a", "scan", false, 500 * time.Millisecond},
+		// it refills at the rate
+		{250 * time.Millisecond, "addr:a", "scan", false, 250 * time.Millisecond},
+		{500 * time.Millisecond, "addr:a", "scan", true, 0},
+		{500 * time.Millisecond, "addr:a", "scan", false, 500 * time.Millisecond},
+		// but not beyond the burst
+		{time.Hour, "addr:a", "scan", true, 0},
+		{time.Hour, "addr:a", "scan", true, 0},
+		{time.Hour, "addr:a", "scan", true, 0},
+		{time.Hour, "addr:a", "scan", false, 500 * time.Millisecond},
+		// clients and routes have buckets of their own
+		{time.Hour, "addr:b", "scan", true, 0},
+		{time.Hour, "addr:a", "next", true, 0},
+		// routes without a limit take the one of any route, the burst
+		// defaults to the rate
+		{time.Hour, "addr:a", "next", false, time.Second},
+		// a client with a limit of its own has it on every route
+		{0, "key:k1", "scan", true, 0},
+		{0, "key:k1", "scan", true, 0},
+		{0, "key:k1", "scan", true, 0},
+		{0, "key:k1", "scan", true, 0},
+		{0, "key:k1", "scan", true, 0},
+		{0, "key:k1", "scan", false, 100 * time.Millisecond},
+		{0, "key:k1", "next", true, 0},
+	}
+	for i, step := range steps {
+		allowed, wait := l.Allow(step.client, step.route, start.Add(step.after))
+		if allowed != step.allowed || wait != step.wait {
+			t.Errorf("step %d: Allow(%s, %s) = %t, %v, want %t, %v", i, step.client, step.route, allowed, wait, step.allowed, step.wait)
+		}
+	}
+	if limited := l.Stats().Limited; limited != 6 {
+		t.Errorf("Stats().Limited = %d, want 6", limited)
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	l := New(Config{Routes: map[string]Limit{"scan": {Rate: 0, Burst: 1}}})
+	now := time.Unix(1500000000, 0)
+	for i := 0; i < 100; i++ {
+		if allowed, _ := l.Allow("addr:a", "scan", now); !allowed {
+			t.Fatalf("request %d without a limit was refused", i)
+		}
+	}
+	if buckets := l.Stats().Buckets; buckets != 0 {
+		t.Errorf("Stats().Buckets = %d, want 0", buckets)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	l := New(Config{MaxConcurrent: 2})
+	if !l.Acquire() || !l.Acquire() {
+		t.Fatalf("Acquire() refused below MaxConcurrent")
+	}
+	if l.Acquire() {
+		t.Errorf("Acquire() admitted beyond MaxConcurrent")
+	}
+	l.Release()
+	if !l.Acquire() {
+		t.Errorf("Acquire() refused after Release()")
+	}
+	if stats := l.Stats(); stats.InFlight != 2 || stats.Shed != 1 {
+		t.Errorf("Stats() = %+v, want 2 in flight and 1 shed", stats)
+	}
+}
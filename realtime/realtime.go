@@ -5,28 +5,83 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"engines/github.com.blackjack.syslog"
 	"engines/github.com.bmizerany.pat"
 
 	"realtime/account_store"
+	"realtime/apikey"
+	"realtime/audit"
 	"realtime/credential"
+	"realtime/deadletter"
+	"realtime/itembuffer"
+	"realtime/listener"
 	"realtime/manager"
 	"realtime/monitors/fakestream"
+	"realtime/monitors/feedpoll"
+	"realtime/monitors/restpoll"
 	"realtime/monitors/twitterstream"
+	"realtime/monitors/webhook"
+	"realtime/ratelimit"
 )
 
 var port *string = flag.String("port", "", "Please enter the port for the client to listen on. Port is required.")
+var feed_url_template *string = flag.String("feed_url_template", "", "Feed url template with a %s for the account id, when empty feedpoll account ids are base64 encoded feed urls.")
+var webhook_account_path *string = flag.String("webhook_account_path", "account_ids[]", "Path to the account ids in webhook payloads, e.g. events[].account.id")
+var webhook_secret *string = flag.String("webhook_secret", "", "Shared secret for HMAC-SHA256 signed webhook payloads.")
+var webhook_oauth_consumers *string = flag.String("webhook_oauth_consumers", "", "Comma separated key:secret OAuth 1.0a consumers allowed to post webhook payloads.")
+var webhook_oauth_rsa_consumers *string = flag.String("webhook_oauth_rsa_consumers", "", "Comma separated key:path OAuth 1.0a consumers signing with RSA-SHA1, path is a PEM public key or certificate.")
+var trusted_proxies *string = flag.String("trusted_proxies", "", "Comma separated addresses or CIDR ranges of the proxies in front of the webhook ingest whose X-Forwarded-Proto is trusted.")
+var restpoll_url_template *string = flag.String("restpoll_url_template", "", "Timeline url with {id} for the account id, restpoll is disabled when empty.")
+var restpoll_params *string = flag.String("restpoll_params", "", "Query string sent to the timeline url, values may contain {id}, e.g. user_id={id}&count=200")
+var restpoll_item_path *string = flag.String("restpoll_item_path", "[].id_str", "Path to the item ids in timeline responses.")
+var restpoll_verify_url *string = flag.String("restpoll_verify_url", "", "Url requested to check restpoll credentials with the source, e.g. https://api.twitter.com/1.1/account/verify_credentials.json")
+var restpoll_global_budget *int = flag.Int("restpoll_global_budget", 0, "Maximum timeline requests per 15 minutes across all credentials, 0 for no limit.")
+var lifecycle *string = flag.String("lifecycle", "", "Comma separated property=dormant_after[/remove_after] policies for accounts no scanner asks about, e.g. twitterstream=720h/2160h,*=168h")
+var capacity *string = flag.String("capacity", "", "Comma separated property=accounts caps on how many accounts a connector follows, e.g. twitterstream=5000")
+var deadletter_capacity *int = flag.Int("deadletter_capacity", 1000, "Number of quarantined messages kept in memory.")
+var item_buffer_bytes *int64 = flag.Int64("item_buffer_bytes", 0, "Memory shared by the buffers of raw content received per account, 0 turns buffering off.")
+var item_buffer_count *int = flag.Int("item_buffer_count", itembuffer.DEFAULT_MAX_ITEMS, "Number of raw items buffered per account.")
+var item_buffer_age *time.Duration = flag.Duration("item_buffer_age", itembuffer.DEFAULT_MAX_AGE, "Age after which buffered raw items are dropped.")
+var credential_fallback *time.Duration = flag.Duration("credential_fallback", credential.DEFAULT_FALLBACK_WINDOW, "How long a rotated out credential is kept to fall back to when its replacement stops working.")
+var credential_files *string = flag.String("credential_files", "", "Comma separated property=path JSON credential files, readable by their owner only.")
+var credential_check *time.Duration = flag.Duration("credential_check", 1*time.Hour, "Interval at which credentials in use are verified with their sources, 0 turns the checks off.")
+var keystore_path *string = flag.String("keystore", "", "Encrypted file credentials are loaded from and kept in across restarts.")
+var keystore_key_env *string = flag.String("keystore_key_env", "REALTIME_KEYSTORE_KEY", "Environment variable holding the base64 or hex 32 byte master key of the keystore.")
+var api_keys_path *string = flag.String("api_keys", "", "File of hashed api keys, every request but signed ingests then needs an X-Api-Key with the scopes of its route. Create the first key with: realtime keys create -file <file> -scopes '*'")
+var audit_file *string = flag.String("audit_file", "", "File management and account-changing actions are appended to, only the most recent are kept in memory when empty.")
+var audit_max_bytes *int64 = flag.Int64("audit_max_bytes", audit.DEFAULT_MAX_BYTES, "Size at which the audit file is rotated.")
+var audit_keep *int = flag.Int("audit_keep", audit.DEFAULT_KEEP, "Number of rotated audit files kept.")
+var rate_limits *string = flag.String("rate_limits", "", "Comma separated route=rate[/burst] requests a second each client may make, routes are scan, items, next, labels, ingest, manage, unauthenticated for requests without an api key or client certificate and * for the others, e.g. scan=20/40,*=50")
+var max_concurrent *int = flag.Int("max_concurrent", 0, "Requests in flight beyond which further ones are shed with 503, management requests excepted, 0 for no limit.")
+var tls_cert *string = flag.String("tls_cert", "", "PEM certificate the api listeners serve TLS with, plaintext when empty.")
+var tls_key *string = flag.String("tls_key", "", "PEM private key of tls_cert.")
+var tls_client_ca *string = flag.String("tls_client_ca", "", "PEM bundle of the CAs client certificates are verified against.")
+var tls_client_auth *string = flag.String("tls_client_auth", "", "optional or require, verified client certificates stand for the api key bound to their common name.")
+var tls_watch *time.Duration = flag.Duration("tls_watch", listener.DEFAULT_WATCH_INTERVAL, "Interval at which the tls files are checked for changes, SIGHUP reloads them right away.")
+var manage_port *string = flag.String("manage_port", "", "Port the management api listens on apart from the scan api, on port with it when empty.")
+var pprof_addr *string = flag.String("pprof_addr", "localhost:6060", "Address pprof listens on in plaintext, disabled when empty.")
+var state_dir *string = flag.String("state_dir", "", "Directory the accounts of every store are saved to on shutdown and restored from on startup, nothing is kept when empty.")
+var drain_timeout *time.Duration = flag.Duration("drain_timeout", 15*time.Second, "How long requests in flight are given to finish on shutdown.")
+var shutdown_timeout *time.Duration = flag.Duration("shutdown_timeout", 30*time.Second, "How long shutdown may take in all before the process exits regardless, a second signal exits right away.")
+var deadletter_dir *string = flag.String("deadletter_dir", "", "Directory quarantined messages are spilled to once pushed out of memory, no spill when empty.")
 
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 	flag.Parse()
 	if *port == "" {
 		log.Println("Port is required.")
 		os.Exit(1)
 	}
+	credential.FallbackWindow = *credential_fallback
 	syslog.Openlog("realtime", syslog.LOG_PID, syslog.LOG_DEBUG)
 	syslog.Noticef("Initialized on port %s", *port)
 	syslog.Debugf("Initialized on port %s", *port)
@@ -34,45 +89,279 @@ func main() {
 	// handle control-c and kill
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	r := pat.New()
+	dead_letters := deadletter.New(*deadletter_capacity, *deadletter_dir)
 
 	twitter_store := account_store.New(true)
+	twitter_store.Property = twitterstream.PROPERTY
 	twitter_credential := credential.NewCredential()
 	twitter_connector := twitterstream.NewConnector(twitter_store, twitter_credential)
 	twitter_router := twitterstream.NewRouter(twitter_store, twitter_credential, r)
 
 	fake_store := account_store.New(true)
+	fake_store.Property = fakestream.PROPERTY
 	fake_credential := credential.NewCredential()
 	fake_manager := fakestream.NewConnector(fake_store, fake_credential)
 	fake_router := fakestream.NewRouter(fake_store, fake_credential, r)
 
-	monitoredArr := []manager.Manager{twitter_connector, twitter_router, fake_manager, fake_router}
+	feed_store := account_store.New(false)
+	feed_store.Property = feedpoll.PROPERTY
+	feed_credential := credential.NewOptionalCredential()
+	feed_connector := feedpoll.NewConnector(feed_store, feed_credential, *feed_url_template)
+	feed_router := feedpoll.NewRouter(feed_store, feed_credential, r)
+
+	webhook_consumers := make(map[string]string)
+	for _, consumer := range strings.Split(*webhook_oauth_consumers, ",") {
+		if i := strings.Index(consumer, ":"); i > 0 {
+			webhook_consumers[consumer[:i]] = consumer[i+1:]
+		}
+	}
+	webhook_rsa_consumers, err := loadRsaConsumers(*webhook_oauth_rsa_consumers)
+	if err != nil {
+		log.Println("Unable to load webhook_oauth_rsa_consumers: ", err)
+		os.Exit(1)
+	}
+	webhook_proxies, err := manager.ParseTrustedProxies(*trusted_proxies)
+	if err != nil {
+		log.Println("Unable to parse trusted_proxies: ", err)
+		os.Exit(1)
+	}
+	webhook_store := account_store.New(false)
+	webhook_store.Property = webhook.PROPERTY
+	webhook_credential := credential.NewOptionalCredential()
+	webhook_connector := webhook.NewConnector(webhook_store, webhook_credential, r, manager.IngestConfig{
+		AccountIdPath:     *webhook_account_path,
+		HmacSecret:        *webhook_secret,
+		OauthConsumers:    webhook_consumers,
+		OauthRsaConsumers: webhook_rsa_consumers,
+		TrustedProxies:    webhook_proxies,
+	})
+	webhook_router := webhook.NewRouter(webhook_store, webhook_credential, r)
+
+	monitoredArr := []manager.Manager{twitter_connector, twitter_router, fake_manager, fake_router, feed_connector, feed_router, webhook_connector, webhook_router}
+
+	lifecycle_policies, err := account_store.ParseLifecyclePolicies(*lifecycle)
+	if err != nil {
+		log.Println("Unable to parse lifecycle: ", err)
+		os.Exit(1)
+	}
+	capacities, err := account_store.ParseCapacities(*capacity)
+	if err != nil {
+		log.Println("Unable to parse capacity: ", err)
+		os.Exit(1)
+	}
+	var items *itembuffer.Buffer
+	if *item_buffer_bytes > 0 {
+		items = itembuffer.New(*item_buffer_bytes, *item_buffer_count, *item_buffer_age)
+	}
+	// configure applies the settings of a property to one of its stores
+	configure := func(store *account_store.Store, property account_store.Property) {
+		policy, present := lifecycle_policies[property]
+		if !present {
+			policy = lifecycle_policies[account_store.ANY_PROPERTY]
+		}
+		store.SetLifecyclePolicy(policy)
+		store.SetCapacity(capacities[property])
+		if items != nil {
+			store.SetItemBuffer(items)
+		}
+	}
+
+	if *restpoll_url_template != "" {
+		params, err := url.ParseQuery(*restpoll_params)
+		if err != nil {
+			log.Println("Unable to parse restpoll_params: ", err)
+			os.Exit(1)
+		}
+		rest_config := restpoll.Config{
+			UrlTemplate:  *restpoll_url_template,
+			Params:       params,
+			ItemIdPath:   *restpoll_item_path,
+			GlobalBudget: *restpoll_global_budget,
+			VerifyUrl:    *restpoll_verify_url,
+		}
+		rest_store := account_store.New(false)
+		rest_store.Property = restpoll.PROPERTY
+		rest_credential := credential.NewCredential()
+		rest_connector := restpoll.NewConnector(rest_store, rest_credential, rest_config)
+		rest_router := restpoll.NewRouter(rest_store, rest_credential, r)
+		rest_connector.SetDeadLetters(dead_letters)
+		rest_router.EnableTenants(manager.NewTenants(restpoll.NAME, false, false, func(store *account_store.Store, credential *credential.Credential) manager.Manager {
+			configure(store, restpoll.PROPERTY)
+			connector := restpoll.NewConnector(store, credential, rest_config)
+			connector.SetDeadLetters(dead_letters)
+			return connector
+		}))
+		monitoredArr = append(monitoredArr, rest_connector, rest_router)
+	}
+
+	credentials := make(map[string]*credential.Credential)
+	for _, m := range monitoredArr {
+		credentials[m.Name()] = m.Credential()
+	}
+	keystore, err := loadCredentials(credentials)
+	if err != nil {
+		log.Println("Unable to load credentials: ", err)
+		os.Exit(1)
+	}
+
+	twitter_connector.SetDeadLetters(dead_letters)
+	fake_manager.SetDeadLetters(dead_letters)
+	feed_connector.SetDeadLetters(dead_letters)
+	webhook_connector.SetDeadLetters(dead_letters)
+
+	for _, store := range manager.Stores(monitoredArr) {
+		configure(store, store.Property)
+	}
+
+	twitter_router.EnableTenants(manager.NewTenants(twitterstream.NAME, true, false, func(store *account_store.Store, credential *credential.Credential) manager.Manager {
+		configure(store, twitterstream.PROPERTY)
+		connector := twitterstream.NewConnector(store, credential)
+		connector.SetDeadLetters(dead_letters)
+		return connector
+	}))
+	fake_router.EnableTenants(manager.NewTenants(fakestream.NAME, true, false, func(store *account_store.Store, credential *credential.Credential) manager.Manager {
+		configure(store, fakestream.PROPERTY)
+		connector := fakestream.NewConnector(store, credential)
+		connector.SetDeadLetters(dead_letters)
+		return connector
+	}))
+	feed_router.EnableTenants(manager.NewTenants(feedpoll.NAME, false, true, func(store *account_store.Store, credential *credential.Credential) manager.Manager {
+		configure(store, feedpoll.PROPERTY)
+		connector := feedpoll.NewConnector(store, credential, *feed_url_template)
+		connector.SetDeadLetters(dead_letters)
+		return connector
+	}))
 
 	management := manager.NewHttpManagement(&monitoredArr)
+	management.DeadLetters = dead_letters
+	management.Items = items
+	audit_log, err := audit.Open(*audit_file, *audit_max_bytes, *audit_keep)
+	if err != nil {
+		log.Println("Unable to open audit file: ", err)
+		os.Exit(1)
+	}
+	manager.AuditLog = audit_log
+	// rotations made through the api are recorded with their actor there,
+	// reverts are the connectors' own doing
+	credential.RotationHook = func(e credential.RotationEvent) {
+		if e.Event == credential.ROTATION_REVERTED {
+			audit_log.Record(audit.Entry{Actor: audit.ACTOR_SYSTEM, Action: "credential.revert", Target: e.To, Before: e.From, After: e.To, Outcome: audit.OUTCOME_OK, Detail: e.Detail})
+		}
+	}
+
+	routes, err := ratelimit.ParseRoutes(*rate_limits)
+	if err != nil {
+		log.Println("Unable to parse rate_limits: ", err)
+		os.Exit(1)
+	}
+	limiter := ratelimit.New(ratelimit.Config{Routes: routes, MaxConcurrent: *max_concurrent})
+	management.Limiter = limiter
+
+	// the requests in flight and unauthenticated addresses are limited
+	// before the api key check, keys, certificates and addresses after it
+	var handler http.Handler = manager.RateLimit(limiter, r)
+	var api_keys *apikey.Store
+	if *api_keys_path != "" {
+		api_keys, err = apikey.Open(*api_keys_path)
+		if err != nil {
+			log.Println("Unable to open api keys: ", err)
+			os.Exit(1)
+		}
+		management.Keys = api_keys
+		handler = manager.RequireApiKeys(api_keys, handler)
+		go api_keys.FlushEvery(apikey.USAGE_SAVE_INTERVAL)
+	}
+	handler = manager.LimitAddresses(limiter, handler)
 	management.SetRoutes(r)
 
-	http.Handle("/", r)
-	go http.ListenAndServe(":"+*port, nil)
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
+	var tls_files *listener.TLS
+	if *tls_cert != "" || *tls_key != "" {
+		tls_files, err = listener.NewTLS(listener.Config{CertFile: *tls_cert, KeyFile: *tls_key, ClientCAFile: *tls_client_ca, ClientAuth: *tls_client_auth})
+		if err != nil {
+			log.Println("Unable to load tls files: ", err)
+			os.Exit(1)
+		}
+		go tls_files.Watch(*tls_watch)
+	} else if *tls_client_ca != "" || *tls_client_auth != "" {
+		log.Println("Client certificates need tls_cert and tls_key.")
+		os.Exit(1)
+	}
+
+	type api_listener struct {
+		name    string
+		addr    string
+		handler http.Handler
+		tls     *listener.TLS
+	}
+	listeners := []api_listener{{"api", ":" + *port, handler, tls_files}}
+	if *manage_port != "" {
+		listeners = []api_listener{
+			{"scan api", ":" + *port, manager.SplitRoutes(false, handler), tls_files},
+			{"management api", ":" + *manage_port, manager.SplitRoutes(true, handler), tls_files},
+		}
+	}
+	// pprof registers on the default mux, which only its own listener serves
+	if *pprof_addr != "" {
+		listeners = append(listeners, api_listener{"pprof", *pprof_addr, http.DefaultServeMux, nil})
+	}
 
 	//monitoredArr := []monitors.Managed{twitter_manager, fake_manager}
 	go manager.RestartMonitor(monitoredArr)
+	go manager.Housekeeping(monitoredArr)
+	if *credential_check > 0 {
+		go manager.CredentialChecks(monitoredArr, *credential_check)
+	}
 
+	// tenants are recreated before the stores so their accounts are restored
+	if *state_dir != "" {
+		if err := os.MkdirAll(*state_dir, 0700); err != nil {
+			log.Println("Unable to create state_dir: ", err)
+			os.Exit(1)
+		}
+		if err := manager.OpenTenants(monitoredArr, *state_dir, keystore); err != nil {
+			log.Println("Unable to restore tenants: ", err)
+			os.Exit(1)
+		}
+	}
+	if err := restoreStores(manager.Stores(monitoredArr)); err != nil {
+		log.Println("Unable to restore account stores: ", err)
+		os.Exit(1)
+	}
 	for _, m := range monitoredArr {
 		manager.Start(m)
 	}
+	manager.StartTenants(monitoredArr)
+
+	// the apis listen once tenants and accounts are restored, a request
+	// served earlier would find them missing
+	var servers []*http.Server
+	for _, l := range listeners {
+		server, err := listener.Listen(l.name, l.addr, l.handler, l.tls)
+		if err != nil {
+			log.Println("Unable to listen for the "+l.name+": ", err)
+			os.Exit(1)
+		}
+		servers = append(servers, server)
+	}
 
 	for {
 		select {
+		case <-hup:
+			if tls_files != nil {
+				if err := tls_files.Reload(); err != nil {
+					syslog.Errf("unable to reload tls files, keeping the previous ones: %s", err)
+				}
+			}
 		case <-c:
 			syslog.Notice("Exiting")
-			for _, m := range monitoredArr {
-				manager.Stop(m)
-			}
-			os.Exit(1)
+			exiting := &shutdown{servers: servers, managers: monitoredArr, dead_letters: dead_letters, api_keys: api_keys, audit_log: audit_log}
+			code := exiting.run(c, *drain_timeout, *shutdown_timeout)
+			syslog.Closelog()
+			os.Exit(code)
 		}
 	}
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/credential"
+)
+
+// loadCredentials gives each property the credential of its secret source:
+// a file named in credential_files first, then REALTIME_<PROPERTY>_*
+// environment variables, then the keystore. With a keystore every later
+// change of a credential is written back to it, the keystore is returned for
+// the tenants' credentials.
+func loadCredentials(credentials map[string]*credential.Credential) (*credential.Keystore, error) {
+	files := make(map[string]string)
+	for _, entry := range strings.Split(*credential_files, ",") {
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		if i <= 0 {
+			return nil, errors.New("credential_files entries must be property=path")
+		}
+		files[entry[:i]] = entry[i+1:]
+	}
+
+	var keystore *credential.Keystore
+	if *keystore_path != "" {
+		master_key, err := credential.ParseMasterKey(os.Getenv(*keystore_key_env))
+		if err != nil {
+			return nil, errors.New(*keystore_key_env + ": " + err.Error())
+		}
+		keystore, err = credential.OpenKeystore(*keystore_path, master_key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for property, c := range credentials {
+		var json_credential *credential.JsonCredential
+		var source string
+		var err error
+		if path, present := files[property]; present {
+			json_credential, err = credential.FromFile(path)
+			source = credential.SOURCE_FILE
+		} else {
+			json_credential, err = credential.FromEnv(credential.EnvPrefix(property))
+			source = credential.SOURCE_ENV
+		}
+		if err != nil {
+			return nil, err
+		}
+		if json_credential == nil && keystore != nil {
+			json_credential, _ = keystore.Get(property)
+			source = credential.SOURCE_KEYSTORE
+		}
+		if json_credential != nil && !c.Accepts(json_credential) {
+			return nil, errors.New(property + " credential from " + source + " is not of a kind its connector signs with")
+		}
+		if json_credential != nil {
+			c.Load(json_credential, source)
+			syslog.Noticef("%s loaded %s from %s", property, c, source)
+		}
+		if keystore != nil {
+			name := property
+			c.SetPersist(func(json_credential *credential.JsonCredential) {
+				if err := keystore.Put(name, json_credential); err != nil {
+					syslog.Errf("unable to keep %s credential in the keystore: %s", name, err)
+				}
+			})
+		}
+	}
+	return keystore, nil
+}
+
+// loadRsaConsumers reads the public keys of key:path OAuth consumers, a path
+// holds a PEM public key or certificate.
+func loadRsaConsumers(spec string) (map[string]*rsa.PublicKey, error) {
+	consumers := make(map[string]*rsa.PublicKey)
+	for _, entry := range strings.Split(spec, ",") {
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, ":")
+		if i <= 0 {
+			return nil, errors.New("entries must be key:path")
+		}
+		p, err := ioutil.ReadFile(entry[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(p)
+		if block == nil {
+			return nil, errors.New(entry[i+1:] + " is not PEM encoded")
+		}
+		var public_key interface{}
+		if block.Type == "CERTIFICATE" {
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			public_key = certificate.PublicKey
+		} else if public_key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+		rsa_key, ok := public_key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New(entry[i+1:] + " is not an RSA public key")
+		}
+		consumers[entry[:i]] = rsa_key
+	}
+	return consumers, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"engines/github.com.blackjack.syslog"
+
+	"realtime/account_store"
+	"realtime/apikey"
+	"realtime/audit"
+	"realtime/deadletter"
+	"realtime/manager"
+)
+
+// shutdown holds what main hands over to be stopped, saved and flushed on
+// exit.
+type shutdown struct {
+	servers      []*http.Server
+	managers     []manager.Manager
+	dead_letters *deadletter.Store
+	api_keys     *apikey.Store
+	audit_log    *audit.Log
+}
+
+// run stops accepting connections and lets requests in flight finish within
+// drain, then stops the managers, saves the stores and flushes the logs. It
+// returns the exit code, 0 for a clean shutdown. Past deadline or on another
+// signal it gives up and returns 1.
+func (s *shutdown) run(signals <-chan os.Signal, drain time.Duration, deadline time.Duration) int {
+	done := make(chan bool, 1)
+	go func() {
+		done <- s.stop(drain)
+	}()
+	select {
+	case clean := <-done:
+		if clean {
+			syslog.Notice("Shut down cleanly")
+			return 0
+		}
+		syslog.Warning("Shut down with errors")
+	case <-time.After(deadline):
+		syslog.Errf("Shutdown took longer than %s, exiting", deadline)
+	case <-signals:
+		syslog.Warning("Signaled again, exiting")
+	}
+	return 1
+}
+
+func (s *shutdown) stop(drain time.Duration) bool {
+	clean := true
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	drained := make(chan bool, len(s.servers))
+	for _, server := range s.servers {
+		go func(server *http.Server) {
+			err := server.Shutdown(ctx)
+			if err != nil {
+				syslog.Errf("requests to %s still in flight after %s, closing them: %s", server.Addr, drain, err)
+				server.Close()
+			}
+			drained <- err == nil
+		}(server)
+	}
+	for range s.servers {
+		clean = <-drained && clean
+	}
+
+	manager.StopAll(s.managers)
+
+	if err := saveStores(manager.Stores(s.managers)); err != nil {
+		syslog.Errf("unable to save account stores: %s", err)
+		clean = false
+	}
+	if err := s.dead_letters.Close(); err != nil {
+		syslog.Errf("unable to spill dead letters: %s", err)
+		clean = false
+	}
+	if s.api_keys != nil {
+		if err := s.api_keys.Flush(); err != nil {
+			syslog.Errf("unable to save api key usage: %s", err)
+			clean = false
+		}
+	}
+	if err := s.audit_log.Close(); err != nil {
+		syslog.Errf("unable to close audit log: %s", err)
+		clean = false
+	}
+	return clean
+}
+
+func stateFile(name string) string {
+	return filepath.Join(*state_dir, name+"."+account_store.FORMAT_NDJSON)
+}
+
+// restoreStores merges the accounts saved on the last shutdown into the
+// stores, a store without a file starts empty. A file without a store, of a
+// property not set up or a tenant not recreated, is an error rather than
+// accounts silently dropped.
+func restoreStores(stores map[string]*account_store.Store) error {
+	if *state_dir == "" {
+		return nil
+	}
+	files, err := ioutil.ReadDir(*state_dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), "."+account_store.FORMAT_NDJSON)
+		if _, present := stores[name]; !present && name != file.Name() {
+			return errors.New(stateFile(name) + " holds accounts of no store, move it away to start without them")
+		}
+	}
+	for name, store := range stores {
+		f, err := os.Open(stateFile(name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		records, line_errors, err := account_store.ReadRecords(f, account_store.FORMAT_NDJSON)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		report := store.Import(records, false)
+		syslog.Noticef("restored %d accounts of %s, %d errors", report.Imported, name, len(line_errors)+len(report.Errors))
+	}
+	return nil
+}
+
+// saveStores writes every store to state_dir, each file is replaced whole so
+// a crash leaves either the old or the new accounts.
+func saveStores(stores map[string]*account_store.Store) error {
+	if *state_dir == "" {
+		return nil
+	}
+	for name, store := range stores {
+		f, err := ioutil.TempFile(*state_dir, name+".")
+		if err != nil {
+			return err
+		}
+		err = account_store.WriteRecords(f, account_store.FORMAT_NDJSON, store.Export())
+		if close_err := f.Close(); err == nil {
+			err = close_err
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), stateFile(name))
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+	}
+	return nil
+}